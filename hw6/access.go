@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Владельцы задач и видимость.
+//
+// Задачу получает во владение тот, кто ее создал (Task.Owner). Задачу видят
+// и могут менять:
+//
+//	- владелец;
+//	- пользователи и группы из Task.SharedWith: "bob", "group:dev";
+//	- администраторы (Principal.Admin) - все задачи.
+//
+// Задачи без владельца (созданные до появления владельцев) видны всем.
+// Владельца и shared_with меняют только владелец и администраторы; убрать
+// владельца нельзя. Для остальных чужая задача не существует: 404, как и
+// на задачу, которой нет, - и в блокерах (blocked_by) тоже.
+//
+// Хранилище ведет индекс "ключ доступа -> задачи" (accessKeys), поэтому
+// список задач пользователя собирается без перебора всех задач (Visible).
+
+var errNotOwner = errors.New("only the owner can change owner and shared_with")
+var errBadSharing = errors.New("invalid shared_with")
+
+// префиксы ключей доступа и записей shared_with
+const (
+	userPrefix  = "user:"
+	groupPrefix = "group:"
+)
+
+// accessKeys - ключи доступа к задаче: владелец и те, с кем она разделена
+// ("" - задача без владельца, ее видят все)
+func accessKeys(task Task) []string {
+	if task.Owner == "" {
+		return []string{""}
+	}
+	keys := []string{userPrefix + task.Owner}
+	for _, s := range task.SharedWith {
+		keys = append(keys, shareKey(s))
+	}
+	return keys
+}
+
+// shareKey - ключ доступа записи shared_with: "bob" или "group:dev"
+func shareKey(s string) string {
+	if strings.HasPrefix(s, groupPrefix) {
+		return s
+	}
+	return userPrefix + s
+}
+
+// accessKeys - ключи доступа, по которым p видит задачи
+func (p Principal) accessKeys() []string {
+	keys := []string{"", userPrefix + p.Name}
+	for _, g := range p.Groups {
+		keys = append(keys, groupPrefix+g)
+	}
+	return keys
+}
+
+// canSee - видна ли p задача task
+func (p Principal) canSee(task Task) bool {
+	if p.Admin {
+		return true
+	}
+	mine := p.accessKeys()
+	for _, key := range accessKeys(task) {
+		if slices.Contains(mine, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// canShare - может ли p менять владельца и shared_with задачи task
+func (p Principal) canShare(task Task) bool {
+	return p.Admin || task.Owner == p.Name
+}
+
+// scope - часть ETag списков задач: разные пользователи видят разные списки
+// при одной ревизии хранилища ("" - администратор видит все)
+func (p Principal) scope() string {
+	if p.Admin {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(p.accessKeys(), "\n")))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// authorizeWrite проверяет, может ли p записать задачу task поверх current
+// (current.ID == "" - новая задача), и проставляет владельца: новой
+// задаче - p (администратор может указать другого), измененной - прежнего,
+// если в task он не указан. tasks - задачи хранилища (для блокеров).
+func authorizeWrite(p Principal, task *Task, current Task, tasks map[string]Task) error {
+	if current.ID != "" && !p.canSee(current) {
+		return errTaskNotFound
+	}
+	switch {
+	case current.ID == "" && (!p.Admin || task.Owner == ""):
+		task.Owner = p.Name
+	case current.ID != "" && task.Owner == "":
+		task.Owner = current.Owner
+	}
+	seen := make(map[string]bool, len(task.SharedWith))
+	for _, s := range task.SharedWith {
+		name := strings.TrimPrefix(s, groupPrefix)
+		if name == "" || strings.TrimSpace(s) != s || seen[s] {
+			return fmt.Errorf("%w: bad or duplicate entry %q", errBadSharing, s)
+		}
+		seen[s] = true
+	}
+	if current.ID != "" && (task.Owner != current.Owner || !slices.Equal(task.SharedWith, current.SharedWith)) && !p.canShare(current) {
+		return errNotOwner
+	}
+
+	// чужие задачи нельзя сделать блокерами (как и несуществующие)
+	for _, id := range task.BlockedBy {
+		if blocker, ok := tasks[id]; ok && !p.canSee(blocker) && !slices.Contains(current.BlockedBy, id) {
+			return fmt.Errorf("%w: task %s not found", errBadBlocker, id)
+		}
+	}
+	return nil
+}
+
+// accessError отправляет ответ на ошибку authorizeWrite
+func accessError(c *gin.Context, err error) {
+	if errors.Is(err, errNotOwner) {
+		forbidden(c, reasonNotOwner, err, nil)
+		return
+	}
+	c.JSON(accessStatus(err), gin.H{"error": err.Error()})
+}
+
+// accessStatus - код ответа на ошибку authorizeWrite
+func accessStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, errBadSharing), errors.Is(err, errBadBlocker):
+		return http.StatusBadRequest
+	default:
+		return storeStatus(err)
+	}
+}
+
+// visibleTasks - задачи, которые видит p (по возрастанию Seq)
+func (h *handlers) visibleTasks(p Principal) []Task {
+	if p.Admin {
+		return h.store.List()
+	}
+	return h.store.Visible(p.accessKeys())
+}
+
+// visibleTask - задача id, если ее видит p (иначе errTaskNotFound)
+func (h *handlers) visibleTask(p Principal, id string) (Task, error) {
+	task, err := h.store.Get(id)
+	if err != nil {
+		return Task{}, err
+	}
+	if !p.canSee(task) {
+		return Task{}, errTaskNotFound
+	}
+	return task, nil
+}
+
+// visibleTrash - задачи в корзине, которые видит p
+func (h *handlers) visibleTrash(p Principal) []Task {
+	trash := h.store.Trash()
+	visible := trash[:0]
+	for _, task := range trash {
+		if p.canSee(task) {
+			visible = append(visible, task)
+		}
+	}
+	return visible
+}
+
+// canSeeID - видит ли p задачу id (в том числе в корзине). Историю задач,
+// удаленных навсегда, видят только администраторы.
+func (h *handlers) canSeeID(p Principal, id string) bool {
+	if p.Admin {
+		return true
+	}
+	if task, err := h.store.Get(id); err == nil {
+		return p.canSee(task)
+	}
+	return slices.ContainsFunc(h.visibleTrash(p), func(t Task) bool { return t.ID == id })
+}
+
+// blockers - блокеры задач list (для markBlocked без чтения всех задач)
+func (h *handlers) blockers(list []Task) map[string]Task {
+	tasks := make(map[string]Task)
+	for _, task := range list {
+		for _, id := range task.BlockedBy {
+			if _, ok := tasks[id]; ok {
+				continue
+			}
+			if blocker, err := h.store.Get(id); err == nil {
+				tasks[id] = blocker
+			}
+		}
+	}
+	return tasks
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAccessIndex(t *testing.T) {
+	s := newMemoryStore([]Task{{ID: "old", Title: "без владельца"}})
+	a, _ := s.Create(Task{Title: "a", Owner: "alice"})
+	b, _ := s.Create(Task{Title: "b", Owner: "bob", SharedWith: []string{"group:dev"}})
+
+	ids := func(keys ...string) []string {
+		var list []string
+		for _, task := range s.Visible(keys) {
+			list = append(list, task.ID)
+		}
+		return list
+	}
+	alice := Principal{Name: "alice", Groups: []string{"dev"}}
+	if got := ids(alice.accessKeys()...); !slices.Equal(got, []string{"old", a.ID, b.ID}) {
+		t.Fatalf("alice sees %v", got)
+	}
+
+	// индекс следует за изменениями, корзиной и восстановлением
+	b.SharedWith = []string{"carol"}
+	b, _ = s.Update(b)
+	if got := ids(alice.accessKeys()...); !slices.Equal(got, []string{"old", a.ID}) {
+		t.Fatalf("after unshare alice sees %v", got)
+	}
+	_ = s.Delete(Task{ID: a.ID})
+	if got := ids(userPrefix + "alice"); len(got) != 0 {
+		t.Fatalf("trashed task is visible: %v", got)
+	}
+	_, _ = s.Restore(Task{ID: a.ID})
+	if got := ids(userPrefix+"alice", userPrefix+"carol"); !slices.Equal(got, []string{a.ID, b.ID}) {
+		t.Fatalf("after restore: %v", got)
+	}
+}
+
+func TestTaskOwnership(t *testing.T) {
+	s := newTestServer(t, nil,
+		apiKey{Name: "alice"},
+		apiKey{Name: "bob", Groups: []string{"dev"}},
+		apiKey{Name: "carol"},
+		apiKey{Name: "root", Admin: true},
+	)
+	do := s.do
+	titles := func(user string) []string {
+		var list []string
+		for _, task := range decodeTasks(t, do(user, http.MethodGet, "/all", nil)) {
+			list = append(list, task.Title)
+		}
+		return list
+	}
+
+	do("alice", http.MethodPost, "/task", Task{Title: "alice's", Owner: "bob"})
+	do("bob", http.MethodPost, "/task", Task{Title: "bob's"})
+	tasks := decodeTasks(t, do("root", http.MethodGet, "/all", nil))
+	if len(tasks) != 2 || tasks[0].Owner != "alice" || tasks[1].Owner != "bob" {
+		t.Fatalf("admin sees %+v", tasks)
+	}
+	mine, bobs := tasks[0], tasks[1]
+
+	// чужие задачи не видны и не меняются: 404, как несуществующие
+	if got := titles("alice"); !slices.Equal(got, []string{"alice's"}) {
+		t.Fatalf("alice sees %v", got)
+	}
+	for _, w := range []*httptest.ResponseRecorder{
+		do("alice", http.MethodGet, "/task/"+bobs.ID, nil),
+		do("alice", http.MethodPut, "/task/"+bobs.ID, Task{Title: "x"}),
+		do("alice", http.MethodDelete, "/tasks/"+bobs.ID, nil),
+		do("alice", http.MethodPut, "/task/"+mine.ID, Task{Title: "x", BlockedBy: []string{bobs.ID}}),
+	} {
+		if w.Code != http.StatusNotFound && w.Code != http.StatusBadRequest {
+			t.Fatalf("foreign task: %d %s", w.Code, w.Body)
+		}
+	}
+	var page taskPage
+	_ = json.Unmarshal(do("carol", http.MethodGet, "/tasks", nil).Body.Bytes(), &page)
+	if page.Total != 0 {
+		t.Fatalf("carol's page = %+v", page)
+	}
+
+	// alice делится задачей с группой dev: bob видит и меняет ее,
+	// но shared_with меняет только владелец
+	mine.SharedWith = []string{"group:dev"}
+	if w := do("alice", http.MethodPut, "/task/"+mine.ID, mine); w.Code != http.StatusOK {
+		t.Fatalf("share: %d %s", w.Code, w.Body)
+	}
+	if got := titles("bob"); !slices.Equal(got, []string{"alice's", "bob's"}) {
+		t.Fatalf("bob sees %v", got)
+	}
+	if w := do("bob", http.MethodPatch, "/task/"+mine.ID, map[string]any{"priority": 3}); w.Code != http.StatusOK {
+		t.Fatalf("shared patch: %d %s", w.Code, w.Body)
+	}
+	if w := do("bob", http.MethodPatch, "/task/"+mine.ID, map[string]any{"shared_with": []string{"carol"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("reshare by non-owner: %d %s", w.Code, w.Body)
+	}
+
+	// у каждого свой ETag списка при одной ревизии хранилища
+	if do("alice", http.MethodGet, "/tasks", nil).Header().Get("ETag") == do("bob", http.MethodGet, "/tasks", nil).Header().Get("ETag") {
+		t.Fatal("list ETag does not depend on the caller")
+	}
+
+	// корзина тоже своя; вебхуки - только для администраторов
+	do("bob", http.MethodDelete, "/tasks/"+bobs.ID, nil)
+	var trash []Task
+	_ = json.Unmarshal(do("alice", http.MethodGet, "/trash", nil).Body.Bytes(), &trash)
+	if len(trash) != 0 {
+		t.Fatalf("alice's trash = %+v", trash)
+	}
+	if w := do("alice", http.MethodGet, "/webhooks", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("webhooks for non-admin: %d", w.Code)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Аутентификация: каждый запрос (кроме GET / и POST /auth/token) должен
+// предъявить одно из:
+//
+//	X-API-Key: <ключ>                 - статический ключ из файла (auth.json)
+//	Authorization: Bearer <ключ>      - то же
+//	Authorization: Bearer <токен>     - токен, выданный POST /auth/token
+//
+// Токен - JWT с подписью HS256 (header.claims.signature в base64url),
+// действует auth.token_ttl (config.go). Для GET /events/stream (и того же
+// в проектах) токен можно передать в ?access_token= (EventSource не умеет
+// задавать заголовки).
+//
+// Файл auth.json:
+//
+//	{
+//	  "token_secret": "...",                           // ключ подписи токенов
+//	  "keys":  [{"name": "ci", "key_sha256": "...", "admin": true}],  // или "key": "..."
+//	  "users": [{"name": "alice", "password_hash": "$2a$...", "groups": ["dev"]}]
+//	}
+//
+// Ключи не содержат точек (Bearer с точкой - токен) и не повторяются.
+// password_hash - bcrypt (его печатает ./hw6 -hash-password); groups и admin -
+// для видимости задач (access.go), в токене они записаны на момент входа. Без
+// token_secret ключ подписи выбирается при запуске, и после перезапуска
+// выданные токены перестают действовать.
+//
+// Результат - Principal; обработчики получают его через principalFrom(c).
+
+var errNoCredentials = errors.New("authentication required")
+var errBadCredentials = errors.New("invalid credentials")
+var errBadToken = errors.New("invalid token")
+var errTokenExpired = errors.New("token expired")
+
+// способы аутентификации (Principal.Method)
+const (
+	authAPIKey = "api_key"
+	authToken  = "token"
+	authNone   = "none" // аутентификация выключена (handlers.auth == nil)
+)
+
+// principalKey - ключ Principal в gin.Context
+const principalKey = "principal"
+
+// Principal - автор запроса
+type Principal struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	Admin  bool     `json:"admin,omitempty"` // видит и меняет все задачи (см. access.go)
+	Method string   `json:"method"`
+}
+
+// apiKey - статический ключ; в файле хранится сам ключ или его SHA-256 (hex)
+type apiKey struct {
+	Name      string   `json:"name"`
+	Key       string   `json:"key,omitempty"`
+	KeySHA256 string   `json:"key_sha256,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+}
+
+// authUser - пользователь, который получает токен по паролю
+type authUser struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password_hash"` // bcrypt
+	Groups       []string `json:"groups,omitempty"`
+	Admin        bool     `json:"admin,omitempty"`
+}
+
+// authConfig - содержимое файла ключей
+type authConfig struct {
+	TokenSecret string     `json:"token_secret"`
+	Keys        []apiKey   `json:"keys"`
+	Users       []authUser `json:"users"`
+}
+
+// authenticator проверяет ключи, пароли и токены
+type authenticator struct {
+	keys   map[[sha256.Size]byte]Principal // SHA-256 ключа -> владелец ключа
+	users  map[string]authUser
+	secret []byte
+	now    func() time.Time // часы (в тестах подменяются)
+}
+
+// dummyHash сравнивается с паролем неизвестного пользователя, чтобы ответ
+// по времени не выдавал, есть ли такой пользователь
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// newAuthenticator загружает ключи и пользователей из файла path
+// (если файла нет - никого, и все запросы, кроме входа, получат 401)
+func newAuthenticator(path string) (*authenticator, error) {
+	var cfg authConfig
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	a, err := newAuthenticatorFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+// newAuthenticatorFromConfig создает authenticator по содержимому файла ключей
+func newAuthenticatorFromConfig(cfg authConfig) (*authenticator, error) {
+	a := &authenticator{
+		keys:   make(map[[sha256.Size]byte]Principal),
+		users:  make(map[string]authUser),
+		secret: []byte(cfg.TokenSecret),
+		now:    time.Now,
+	}
+	for i, k := range cfg.Keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("key %d: name is required", i+1)
+		}
+		var sum [sha256.Size]byte
+		switch {
+		case strings.Contains(k.Key, "."):
+			// Bearer с точкой - токен (см. authenticate), такой ключ не подошел бы
+			return nil, fmt.Errorf("key %q: key must not contain \".\"", k.Name)
+		case k.Key != "":
+			sum = sha256.Sum256([]byte(k.Key))
+		case k.KeySHA256 != "":
+			b, err := hex.DecodeString(k.KeySHA256)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("key %q: key_sha256 must be 64 hex digits", k.Name)
+			}
+			copy(sum[:], b)
+		default:
+			return nil, fmt.Errorf("key %q: key or key_sha256 is required", k.Name)
+		}
+		if dup, ok := a.keys[sum]; ok {
+			return nil, fmt.Errorf("key %q: same key as %q", k.Name, dup.Name)
+		}
+		a.keys[sum] = Principal{Name: k.Name, Groups: k.Groups, Admin: k.Admin, Method: authAPIKey}
+	}
+	for i, u := range cfg.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("user %d: name is required", i+1)
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %q: password_hash must be a bcrypt hash", u.Name)
+		}
+		a.users[u.Name] = u
+	}
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		_, err := rand.Read(a.secret)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("auth: no token_secret, tokens will not survive a restart")
+	}
+	return a, nil
+}
+
+// lookupKey ищет статический ключ
+func (a *authenticator) lookupKey(key string) (Principal, bool) {
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	return p, ok
+}
+
+// login проверяет имя и пароль пользователя
+func (a *authenticator) login(name, password string) (Principal, error) {
+	u, ok := a.users[name]
+	hash := []byte(u.PasswordHash)
+	if !ok {
+		hash = dummyHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return Principal{}, errBadCredentials
+	}
+	return Principal{Name: name, Groups: u.Groups, Admin: u.Admin, Method: authToken}, nil
+}
+
+// tokenHeader - заголовок токена (другие алгоритмы, в том числе "none",
+// не принимаются)
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims - содержимое токена
+type tokenClaims struct {
+	Subject   string   `json:"sub"`
+	Groups    []string `json:"groups,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// issueToken выдает токен для p; возвращает токен и время, до которого он действует
+func (a *authenticator) issueToken(p Principal) (string, time.Time, error) {
+	now := a.now()
+	exp := now.Add(time.Duration(currentConfig().Auth.TokenTTL))
+	claims, err := json.Marshal(tokenClaims{Subject: p.Name, Groups: p.Groups, Admin: p.Admin, IssuedAt: now.Unix(), ExpiresAt: exp.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + a.sign(signed), exp, nil
+}
+
+// sign - подпись HS256 строки header.claims
+func (a *authenticator) sign(signed string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken проверяет подпись и срок токена
+func (a *authenticator) verifyToken(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errBadToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, errBadToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return Principal{}, errBadToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, errBadToken
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return Principal{}, errBadToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, errBadToken
+	}
+	var claims tokenClaims
+	if json.Unmarshal(data, &claims) != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return Principal{}, errBadToken
+	}
+	if a.now().Unix() >= claims.ExpiresAt {
+		return Principal{}, errTokenExpired
+	}
+	return Principal{Name: claims.Subject, Groups: claims.Groups, Admin: claims.Admin, Method: authToken}, nil
+}
+
+// authenticate проверяет учетные данные запроса
+func (a *authenticator) authenticate(c *gin.Context) (Principal, error) {
+	credential := c.GetHeader("X-API-Key")
+	if credential != "" {
+		p, ok := a.lookupKey(credential)
+		if !ok {
+			return Principal{}, errBadCredentials
+		}
+		return p, nil
+	}
+	if scheme, value, ok := strings.Cut(c.GetHeader("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		credential = strings.TrimSpace(value)
+	} else if strings.HasSuffix(c.FullPath(), "/events/stream") {
+		credential = c.Query("access_token")
+	}
+	if credential == "" {
+		return Principal{}, errNoCredentials
+	}
+	// в ключах нет точек, в токене их две
+	if !strings.Contains(credential, ".") {
+		p, ok := a.lookupKey(credential)
+		if !ok {
+			return Principal{}, errBadCredentials
+		}
+		return p, nil
+	}
+	return a.verifyToken(credential)
+}
+
+// authenticate - middleware, которое записывает Principal в gin.Context
+// или отвечает 401 (a == nil - аутентификация выключена, например в тестах:
+// все запросы от "anonymous" с правами администратора)
+func authenticate(a *authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Set(principalKey, Principal{Name: "anonymous", Admin: true, Method: authNone})
+			return
+		}
+		p, err := a.authenticate(c)
+		if err != nil {
+			unauthorized(c, err)
+			return
+		}
+		c.Set(principalKey, p)
+	}
+}
+
+// unauthorized отвечает 401 с заголовком WWW-Authenticate (RFC 6750)
+func unauthorized(c *gin.Context, err error) {
+	challenge := `Bearer realm="hw6"`
+	if errors.Is(err, errBadToken) || errors.Is(err, errTokenExpired) {
+		challenge += `, error="invalid_token"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
+
+// principalFrom - автор запроса (записан middleware authenticate)
+func principalFrom(c *gin.Context) Principal {
+	p, _ := c.Get(principalKey)
+	principal, _ := p.(Principal)
+	return principal
+}
+
+// actorFrom - автор изменения (в истории и UpdatedBy)
+func actorFrom(c *gin.Context) string {
+	return principalFrom(c).Name
+}
+
+// обработчик запроса POST /auth/token - вход по имени и паролю
+func (h *handlers) issueToken(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.auth == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "authentication is disabled"})
+		return
+	}
+	p, err := h.auth.login(req.Username, req.Password)
+	if err != nil {
+		unauthorized(c, err)
+		return
+	}
+	token, exp, err := h.auth.issueToken(p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(exp.Sub(h.auth.now()).Seconds()),
+	})
+}
+
+// обработчик запроса GET /auth/me - кто я
+func (h *handlers) whoAmI(c *gin.Context) {
+	c.JSON(http.StatusOK, principalFrom(c))
+}
+
+// hashPassword печатает bcrypt-хеш пароля из первой строки stdin
+// (для password_hash в auth.json)
+func hashPassword() error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	s := newTestServerAuth(t, nil, authConfig{
+		Keys:  []apiKey{{Name: "ci", Key: "ci-key"}},
+		Users: []authUser{{Name: "alice", PasswordHash: string(hash)}},
+	})
+	clock := time.Now()
+	s.h.auth.now = func() time.Time { return clock }
+	do := func(method, url string, body any, header ...string) *httptest.ResponseRecorder {
+		return s.do("", method, url, body, header...)
+	}
+	whoAmI := func(header ...string) (int, Principal) {
+		w := do(http.MethodGet, "/auth/me", nil, header...)
+		var p Principal
+		_ = json.Unmarshal(w.Body.Bytes(), &p)
+		return w.Code, p
+	}
+
+	// без учетных данных - 401 с WWW-Authenticate, главная страница открыта
+	if w := do(http.MethodDelete, "/tasks/x", nil); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("no credentials: %d %v", w.Code, w.Header())
+	}
+	if w := do(http.MethodGet, "/", nil); w.Code != http.StatusOK {
+		t.Fatalf("home page: %d", w.Code)
+	}
+
+	// статический ключ
+	for _, header := range [][]string{{"X-API-Key", "ci-key"}, {"Authorization", "Bearer ci-key"}} {
+		if code, p := whoAmI(header...); code != http.StatusOK || p.Name != "ci" || p.Method != authAPIKey {
+			t.Fatalf("%v: %d %+v", header, code, p)
+		}
+	}
+	if code, _ := whoAmI("X-API-Key", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong key: %d", code)
+	}
+
+	// вход по паролю
+	login := map[string]string{"username": "alice", "password": "secret"}
+	for _, bad := range []map[string]string{{"username": "alice", "password": "nope"}, {"username": "bob", "password": "secret"}} {
+		if w := do(http.MethodPost, "/auth/token", bad); w.Code != http.StatusUnauthorized {
+			t.Fatalf("login %v: %d", bad, w.Code)
+		}
+	}
+	w := do(http.MethodPost, "/auth/token", login)
+	var resp struct {
+		Token     string `json:"access_token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Token == "" || resp.ExpiresIn != int(time.Duration(currentConfig().Auth.TokenTTL).Seconds()) {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	bearer := "Bearer " + resp.Token
+	if code, p := whoAmI("Authorization", bearer); code != http.StatusOK || p.Name != "alice" || p.Method != authToken {
+		t.Fatalf("token: %d %+v", code, p)
+	}
+
+	// автор изменения - аутентифицированный пользователь
+	do(http.MethodPost, "/task", Task{Title: "a"}, "Authorization", bearer)
+	if tasks := decodeTasks(t, do(http.MethodGet, "/all", nil, "Authorization", bearer)); len(tasks) != 1 || tasks[0].UpdatedBy != "alice" {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+
+	// подделанные токены
+	parts := strings.Split(resp.Token, ".")
+	none := `{"alg":"none","typ":"JWT"}`
+	forged := []string{
+		parts[0] + "." + parts[1] + ".",
+		base64.RawURLEncoding.EncodeToString([]byte(none)) + "." + parts[1] + ".",
+		parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2],
+		resp.Token + "x",
+	}
+	for _, token := range forged {
+		if code, _ := whoAmI("Authorization", "Bearer "+token); code != http.StatusUnauthorized {
+			t.Fatalf("forged %q: %d", token, code)
+		}
+	}
+
+	// истекший токен
+	clock = clock.Add(time.Duration(currentConfig().Auth.TokenTTL))
+	if w := do(http.MethodGet, "/auth/me", nil, "Authorization", bearer); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("expired: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestAuthConfigKeys(t *testing.T) {
+	sum := sha256.Sum256([]byte("ci-key"))
+	for _, tc := range []struct {
+		keys []apiKey
+		want string
+	}{
+		{[]apiKey{{Name: "ci", Key: "ci.key"}}, `must not contain "."`},
+		{[]apiKey{{Name: "ci", Key: "ci-key"}, {Name: "deploy", Key: "ci-key"}}, `key "deploy": same key as "ci"`},
+		{[]apiKey{{Name: "ci", Key: "ci-key"}, {Name: "deploy", KeySHA256: hex.EncodeToString(sum[:])}}, `same key as "ci"`},
+		{[]apiKey{{Name: "ci", Key: "ci-key"}, {Name: "deploy", Key: "deploy-key"}}, ""},
+	} {
+		_, err := newAuthenticatorFromConfig(authConfig{Keys: tc.keys, TokenSecret: "s"})
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%+v: %v, want %q", tc.keys, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Пакетные изменения: POST /tasks/batch
+//
+//	{"operations": [
+//		{"op": "create", "task": {"title": "..."}},
+//		{"op": "update", "id": "...", "task": {...}},             - полная замена, как PUT
+//		{"op": "patch",  "id": "...", "task": {"priority": 3}},   - merge patch, как PATCH
+//		{"op": "delete", "id": "...", "version": 4}               - в корзину, как DELETE
+//	]}
+//
+// version (необязательно) - как If-Match: операция выполняется, только если
+// задача не изменилась. Операции проверяются по порядку (каждая видит
+// результат предыдущих) и выполняются все или ни одной, с одной записью
+// на диск. Ссылаться на задачи, созданные в том же пакете, нельзя: их ID
+// станут известны только в ответе.
+
+// maxBatchOps - максимальное число операций в пакете
+var maxBatchOps = 1000
+
+// batchOp - операция пакета
+type batchOp struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Version uint64          `json:"version,omitempty"`
+	Task    json.RawMessage `json:"task,omitempty"`
+}
+
+// batchResult - результат операции пакета (Status - код, как у отдельного запроса)
+type batchResult struct {
+	Op     string `json:"op"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Task   *Task  `json:"task,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// обработчик запроса POST /tasks/batch
+func (h *handlers) batchTasks(c *gin.Context) {
+	var req struct {
+		Operations []batchOp `json:"operations"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOps {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch must have 1 to %d operations", maxBatchOps)})
+		return
+	}
+	p := principalFrom(c)
+	logAttrs(c, "operations", len(req.Operations))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// проверяем все операции на копии задач, чтобы сообщить обо всех ошибках сразу
+	tasks := taskMap(h.store.List())
+	quota := h.taskQuota()
+	ops := make([]change, len(req.Operations))
+	results := make([]batchResult, len(req.Operations))
+	failed := -1
+	for i, op := range req.Operations {
+		results[i] = batchResult{Op: op.Op, ID: op.ID}
+		var status int
+		ops[i], status, err = prepareBatchOp(op, tasks, p)
+		if err == nil && ops[i].Op == opCreate {
+			status, err = http.StatusForbidden, quota.add(ops[i].Task.Owner)
+		}
+		if err != nil {
+			results[i].Status, results[i].Error = status, err.Error()
+			if failed < 0 {
+				failed = i
+			}
+		}
+	}
+	if failed >= 0 {
+		batchFailed(c, results, failed)
+		return
+	}
+
+	done, err := h.store.Batch(ops)
+	var berr *batchError
+	if errors.As(err, &berr) {
+		results[berr.Index].Status, results[berr.Index].Error = storeStatus(berr.Err), berr.Err.Error()
+		batchFailed(c, results, berr.Index)
+		return
+	}
+	if err != nil && !errors.Is(err, errNotSaved) {
+		storeError(c, err)
+		return
+	}
+
+	tasks = taskMap(h.store.List())
+	for i := range done {
+		done[i].Blocked = isBlocked(done[i], tasks)
+		results[i].ID, results[i].Status, results[i].Task = done[i].ID, http.StatusOK, &done[i]
+	}
+	// пакет выполнен, но файл не записан
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"applied": true, "results": results, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"applied": true, "results": results})
+}
+
+// prepareBatchOp проверяет операцию пакета и превращает ее в изменение
+// хранилища от имени p; tasks - задачи с учетом предыдущих операций
+// (обновляется). При ошибке возвращает код ответа для нее.
+func prepareBatchOp(op batchOp, tasks map[string]Task, p Principal) (change, int, error) {
+	switch op.Op {
+	case "create", "update", "patch", "delete":
+	default:
+		return change{}, http.StatusBadRequest, fmt.Errorf("unknown operation %q (want create, update, patch or delete)", op.Op)
+	}
+	if op.Op == "create" {
+		var task Task
+		err := binding.JSON.BindBody(op.Task, &task)
+		if err != nil {
+			return change{}, http.StatusBadRequest, err
+		}
+		task.ID, task.Blocked, task.UpdatedBy = "", false, p.Name
+		err = authorizeWrite(p, &task, Task{}, tasks)
+		if err != nil {
+			return change{}, accessStatus(err), err
+		}
+		err = checkDependencies(task, tasks)
+		if err != nil {
+			return change{}, dependencyStatus(err), err
+		}
+		return change{Op: opCreate, Task: task}, 0, nil
+	}
+
+	current, ok := tasks[op.ID]
+	if !ok || !p.canSee(current) {
+		return change{}, http.StatusNotFound, errTaskNotFound
+	}
+	if op.Version != 0 && op.Version != current.Version {
+		return change{}, http.StatusConflict, fmt.Errorf("%w: current version %s", errVersionMismatch, taskETag(current))
+	}
+
+	if op.Op != "delete" {
+		doc, err := decodeJSON(op.Task)
+		if err != nil {
+			return change{}, http.StatusBadRequest, err
+		}
+		partial := op.Op == "patch"
+		if partial {
+			base, err := taskDocument(current)
+			if err != nil {
+				return change{}, http.StatusInternalServerError, err
+			}
+			doc = mergePatch(base, doc)
+		}
+		task, err := taskFromDocument(doc, current, partial)
+		if err != nil {
+			return change{}, http.StatusBadRequest, err
+		}
+		task.UpdatedBy = p.Name
+		err = authorizeWrite(p, &task, current, tasks)
+		if err != nil {
+			return change{}, accessStatus(err), err
+		}
+		err = checkDependencies(task, tasks)
+		if err != nil {
+			return change{}, dependencyStatus(err), err
+		}
+		// следующие операции с этой задачей увидят ее новую версию
+		next := task
+		next.Version++
+		tasks[task.ID] = next
+		return change{Op: opUpdate, Task: task}, 0, nil
+	}
+	delete(tasks, op.ID)
+	return change{Op: opTrash, Task: Task{ID: op.ID, Version: op.Version, UpdatedBy: p.Name}}, 0, nil
+}
+
+// batchFailed отвечает, что пакет не выполнен: код ответа - код первой
+// неудачной операции failed, остальные операции получают 424 Failed Dependency
+func batchFailed(c *gin.Context, results []batchResult, failed int) {
+	for i := range results {
+		if results[i].Error == "" {
+			results[i].Status, results[i].Error = http.StatusFailedDependency, "not applied: batch failed"
+		}
+	}
+	c.JSON(results[failed].Status, gin.H{"applied": false, "results": results})
+}
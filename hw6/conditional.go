@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskETag - сильный ETag задачи по ее версии
+func taskETag(task Task) string {
+	return fmt.Sprintf(`"v%d"`, task.Version)
+}
+
+// collectionETag - слабый ETag списка задач по ревизии хранилища.
+// epoch отличает запуски сервера: после перезапуска ревизия считается заново;
+// scope - кто смотрит (разные пользователи видят разные задачи, см. access.go).
+func collectionETag(epoch int64, rev uint64, scope string) string {
+	if scope != "" {
+		return fmt.Sprintf(`W/"%x-%d-%s"`, epoch, rev, scope)
+	}
+	return fmt.Sprintf(`W/"%x-%d"`, epoch, rev)
+}
+
+// etagList разбирает заголовок If-Match / If-None-Match
+func etagList(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ifMatch проверяет заголовок If-Match (сильное сравнение).
+// Возвращает версию, которую ожидает клиент (0 - заголовка нет или "*"),
+// и false, если ответ 412 Precondition Failed уже отправлен.
+func ifMatch(c *gin.Context, task Task) (uint64, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	etag := taskETag(task)
+	for _, tag := range etagList(header) {
+		if tag == "*" {
+			return 0, true
+		}
+		if tag == etag {
+			return task.Version, true
+		}
+	}
+	preconditionFailed(c, task)
+	return 0, false
+}
+
+// preconditionFailed - ответ 412 с текущим ETag задачи
+func preconditionFailed(c *gin.Context, task Task) {
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "task was modified, current version " + taskETag(task)})
+}
+
+// notModified ставит ETag и, если он есть в If-None-Match (слабое сравнение),
+// отправляет 304 Not Modified и возвращает true
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	weak := strings.TrimPrefix(etag, "W/")
+	for _, tag := range etagList(c.GetHeader("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == weak {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Настройки сервера.
+//
+// Каждая настройка берется из первого источника, где она задана:
+//
+//	флаг командной строки   -tasks-per-page 20
+//	переменная окружения    HW6_TASKS_PER_PAGE=20
+//	файл TOML               [tasks] per_page = 20
+//	значение по умолчанию   defaultConfig
+//
+// Файл - -config (или HW6_CONFIG), по умолчанию hw6.toml; если файла по
+// умолчанию нет, он не нужен. Неизвестные ключи в файле и неверные значения -
+// ошибка запуска. -print-config печатает итоговые настройки в формате TOML
+// и завершает процесс.
+//
+// По SIGHUP настройки читаются заново из тех же источников. Применяются те,
+// что можно поменять на ходу (configField.Reload: размер страниц, срок
+// хранения в корзине и токенов, ограничения частоты, уровень журнала, время
+// остановки); адрес, доверенные прокси, файлы и формат журнала меняются
+// только перезапуском - об их изменении сервер пишет в журнал. Если новые
+// настройки неверны, остаются прежние.
+
+// envPrefix - префикс переменных окружения
+const envPrefix = "HW6_"
+
+// defaultConfigFile - файл настроек, если -config не задан
+const defaultConfigFile = "hw6.toml"
+
+// Config - настройки сервера
+type Config struct {
+	Addr            string   `toml:"addr"`
+	ShutdownTimeout duration `toml:"shutdown_timeout"`
+	DrainDelay      duration `toml:"drain_delay"`
+	TrustedProxies  string   `toml:"trusted_proxies"` // IP и подсети через запятую
+
+	Storage StorageConfig `toml:"storage"`
+	Auth    AuthConfig    `toml:"auth"`
+	Tasks   TasksConfig   `toml:"tasks"`
+	Rate    RateConfig    `toml:"rate"`
+	Log     LogConfig     `toml:"log"`
+}
+
+// StorageConfig - файлы данных
+type StorageConfig struct {
+	Tasks       string `toml:"tasks"`        // снимок задач
+	WAL         string `toml:"wal"`          // журнал изменений задач
+	History     string `toml:"history"`      // история изменений
+	Webhooks    string `toml:"webhooks"`     // подписки на события
+	Projects    string `toml:"projects"`     // список проектов
+	ProjectsDir string `toml:"projects_dir"` // задачи проектов
+}
+
+// AuthConfig - аутентификация (см. auth.go)
+type AuthConfig struct {
+	File     string   `toml:"file"`
+	TokenTTL duration `toml:"token_ttl"`
+}
+
+// TasksConfig - выдача задач и корзина
+type TasksConfig struct {
+	PerPage        int      `toml:"per_page"`
+	MaxPerPage     int      `toml:"max_per_page"`
+	TrashRetention duration `toml:"trash_retention"`
+}
+
+// RateConfig - ограничение частоты запросов (см. rate.go)
+type RateConfig struct {
+	ReadRate   float64 `toml:"read_rate"`
+	ReadBurst  int     `toml:"read_burst"`
+	WriteRate  float64 `toml:"write_rate"`
+	WriteBurst int     `toml:"write_burst"`
+}
+
+// LogConfig - журнал (см. logging.go)
+type LogConfig struct {
+	Format string `toml:"format"`
+	Level  string `toml:"level"`
+}
+
+// defaultConfig - настройки по умолчанию
+func defaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		ShutdownTimeout: duration(30 * time.Second),
+		DrainDelay:      duration(5 * time.Second),
+		Storage: StorageConfig{
+			Tasks:       "tasks.json",
+			WAL:         "tasks.wal",
+			History:     "history.jsonl",
+			Webhooks:    "webhooks.json",
+			Projects:    "projects.json",
+			ProjectsDir: "projects",
+		},
+		Auth: AuthConfig{File: "auth.json", TokenTTL: duration(time.Hour)},
+		Tasks: TasksConfig{
+			PerPage:        5,
+			MaxPerPage:     100,
+			TrashRetention: duration(30 * 24 * time.Hour),
+		},
+		Rate: RateConfig{ReadRate: 50, ReadBurst: 100, WriteRate: 5, WriteBurst: 20},
+		Log:  LogConfig{Format: "json", Level: "info"},
+	}
+}
+
+// config - действующие настройки (меняются по SIGHUP)
+var config atomic.Pointer[Config]
+
+func init() {
+	c := defaultConfig()
+	config.Store(&c)
+}
+
+// currentConfig - действующие настройки
+func currentConfig() *Config {
+	return config.Load()
+}
+
+// duration - time.Duration, которая в TOML записывается строкой ("30s")
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// configField - настройка, которую можно задать флагом и переменной окружения
+type configField struct {
+	Flag   string
+	Usage  string
+	Reload bool // меняется по SIGHUP без перезапуска
+	Value  func(c *Config) any
+}
+
+// env - имя переменной окружения: HW6_ и имя флага в верхнем регистре
+func (f configField) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(f.Flag, "-", "_"))
+}
+
+// configFields - все настройки; имена флагов прежние, -addr, -token-ttl и т.д.
+var configFields = []configField{
+	{"addr", "address to listen on", false, func(c *Config) any { return &c.Addr }},
+	{"shutdown-timeout", "how long to wait for requests to finish on SIGINT/SIGTERM", true,
+		func(c *Config) any { return &c.ShutdownTimeout }},
+	{"drain-delay", "how long /readyz reports 503 before the server stops accepting connections", true,
+		func(c *Config) any { return &c.DrainDelay }},
+	{"trusted-proxies", "comma-separated proxy IPs or CIDRs whose X-Forwarded-For is trusted (empty - none)", false,
+		func(c *Config) any { return &c.TrustedProxies }},
+	{"tasks-file", "task snapshot file", false, func(c *Config) any { return &c.Storage.Tasks }},
+	{"wal-file", "task change log file", false, func(c *Config) any { return &c.Storage.WAL }},
+	{"history-file", "task history file", false, func(c *Config) any { return &c.Storage.History }},
+	{"webhooks-file", "webhook subscriptions file", false, func(c *Config) any { return &c.Storage.Webhooks }},
+	{"projects-file", "project list file", false, func(c *Config) any { return &c.Storage.Projects }},
+	{"projects-dir", "directory with tasks of each project", false, func(c *Config) any { return &c.Storage.ProjectsDir }},
+	{"auth-file", "API keys and users (see auth.go)", false, func(c *Config) any { return &c.Auth.File }},
+	{"token-ttl", "how long tokens from POST /auth/token are valid", true, func(c *Config) any { return &c.Auth.TokenTTL }},
+	{"tasks-per-page", "default page size of GET /tasks", true, func(c *Config) any { return &c.Tasks.PerPage }},
+	{"max-tasks-per-page", "largest ?limit= of GET /tasks and GET /search", true, func(c *Config) any { return &c.Tasks.MaxPerPage }},
+	{"trash-retention", "how long deleted tasks stay in the trash (0 - forever)", true,
+		func(c *Config) any { return &c.Tasks.TrashRetention }},
+	{"read-rate", "GET requests per second per client (0 - unlimited)", true, func(c *Config) any { return &c.Rate.ReadRate }},
+	{"read-burst", "GET requests a client may send at once", true, func(c *Config) any { return &c.Rate.ReadBurst }},
+	{"write-rate", "other requests per second per client (0 - unlimited)", true, func(c *Config) any { return &c.Rate.WriteRate }},
+	{"write-burst", "other requests a client may send at once", true, func(c *Config) any { return &c.Rate.WriteBurst }},
+	{"log-format", "log format: json or text", false, func(c *Config) any { return &c.Log.Format }},
+	{"log-level", "log level: debug, info, warn or error", true, func(c *Config) any { return &c.Log.Level }},
+}
+
+// setField разбирает значение s настройки f в c
+func setField(c *Config, f configField, s string) error {
+	var err error
+	switch v := f.Value(c).(type) {
+	case *string:
+		*v = s
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *float64:
+		*v, err = strconv.ParseFloat(s, 64)
+	case *duration:
+		err = v.UnmarshalText([]byte(s))
+	default:
+		err = fmt.Errorf("unsupported type %T", v)
+	}
+	return err
+}
+
+// formatField - значение настройки f в c строкой
+func formatField(c *Config, f configField) string {
+	switch v := f.Value(c).(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *float64:
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	case *duration:
+		return time.Duration(*v).String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// configLoader собирает настройки из файла, окружения и флагов;
+// флаги разбираются один раз и снова применяются при перечитывании
+type configLoader struct {
+	path     string // файл настроек
+	explicit bool   // файл задан явно: его отсутствие - ошибка
+	flags    map[string]string
+	getenv   func(string) string
+}
+
+// newConfigLoader добавляет флаги настроек и -config в fs и разбирает args;
+// getenv - источник переменных окружения (os.Getenv)
+func newConfigLoader(fs *flag.FlagSet, args []string, getenv func(string) string) (*configLoader, error) {
+	l := &configLoader{flags: make(map[string]string), getenv: getenv}
+	defaults := defaultConfig()
+	path := fs.String("config", "", "configuration file in TOML (default "+defaultConfigFile+", or "+envPrefix+"CONFIG)")
+	for _, f := range configFields {
+		usage := fmt.Sprintf("%s (env %s)", f.Usage, f.env())
+		fs.Func(f.Flag, usage, func(s string) error {
+			// значение проверяется сразу, чтобы ошибку показал flag
+			var c Config
+			err := setField(&c, f, s)
+			if err != nil {
+				return err
+			}
+			l.flags[f.Flag] = s
+			return nil
+		})
+		// значение по умолчанию в -help
+		fs.Lookup(f.Flag).DefValue = formatField(&defaults, f)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	l.path, l.explicit = *path, *path != ""
+	if !l.explicit {
+		l.path, l.explicit = getenv(envPrefix+"CONFIG"), getenv(envPrefix+"CONFIG") != ""
+	}
+	if !l.explicit {
+		l.path = defaultConfigFile
+	}
+	return l, nil
+}
+
+// load собирает и проверяет настройки
+func (l *configLoader) load() (Config, error) {
+	c := defaultConfig()
+	err := l.loadFile(&c)
+	if err != nil {
+		return c, err
+	}
+	for _, f := range configFields {
+		if s := l.getenv(f.env()); s != "" {
+			err := setField(&c, f, s)
+			if err != nil {
+				return c, fmt.Errorf("%s: %w", f.env(), err)
+			}
+		}
+	}
+	for _, f := range configFields {
+		if s, ok := l.flags[f.Flag]; ok {
+			err := setField(&c, f, s)
+			if err != nil {
+				return c, fmt.Errorf("-%s: %w", f.Flag, err)
+			}
+		}
+	}
+	return c, c.validate()
+}
+
+// loadFile читает файл настроек поверх c
+func (l *configLoader) loadFile(c *Config) error {
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) && !l.explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	next := *c
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&next)
+	var strict *toml.StrictMissingError
+	if errors.As(err, &strict) {
+		return fmt.Errorf("%s: unknown keys:\n%s", l.path, strict.String())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+	*c = next
+	return nil
+}
+
+// validate проверяет настройки и возвращает все ошибки сразу
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Addr != "", "addr must not be empty")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.DrainDelay >= 0, "drain_delay must not be negative")
+	for _, proxy := range c.trustedProxies() {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "trusted_proxies: %q is not an IP or CIDR", proxy)
+	}
+	for _, path := range [][2]string{
+		{"storage.tasks", c.Storage.Tasks}, {"storage.wal", c.Storage.WAL}, {"storage.history", c.Storage.History},
+		{"storage.webhooks", c.Storage.Webhooks}, {"storage.projects", c.Storage.Projects},
+		{"storage.projects_dir", c.Storage.ProjectsDir}, {"auth.file", c.Auth.File},
+	} {
+		check(path[1] != "", "%s must not be empty", path[0])
+	}
+	check(c.Storage.Tasks != c.Storage.WAL, "storage.tasks and storage.wal must differ")
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	check(c.Tasks.PerPage >= 1, "tasks.per_page must be at least 1")
+	check(c.Tasks.MaxPerPage >= c.Tasks.PerPage, "tasks.max_per_page must be at least tasks.per_page")
+	check(c.Tasks.TrashRetention >= 0, "tasks.trash_retention must not be negative")
+	check(c.Rate.ReadRate >= 0 && c.Rate.WriteRate >= 0, "rate.read_rate and rate.write_rate must not be negative")
+	check(c.Rate.ReadRate == 0 || c.Rate.ReadBurst >= 1, "rate.read_burst must be at least 1")
+	check(c.Rate.WriteRate == 0 || c.Rate.WriteBurst >= 1, "rate.write_burst must be at least 1")
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format %q (want json or text)", c.Log.Format)
+	_, err := parseLevel(c.Log.Level)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// trustedProxies - прокси, которым gin верит X-Forwarded-For и X-Real-IP
+// (nil - никому: адрес клиента - адрес соединения)
+func (c Config) trustedProxies() []string {
+	var list []string
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			list = append(list, proxy)
+		}
+	}
+	return list
+}
+
+// readClass и writeClass - ограничения частоты для rateLimiter
+func (c Config) readClass() rateClass {
+	return rateClass{Rate: c.Rate.ReadRate, Burst: c.Rate.ReadBurst}
+}
+
+func (c Config) writeClass() rateClass {
+	return rateClass{Rate: c.Rate.WriteRate, Burst: c.Rate.WriteBurst}
+}
+
+// printConfig - настройки в формате TOML (для -print-config)
+func (c Config) printConfig() ([]byte, error) {
+	return toml.Marshal(c)
+}
+
+// reloadConfig применяет новые настройки next поверх действующих: настройки
+// без configField.Reload остаются прежними; возвращает их флаги, если
+// они изменились (нужен перезапуск)
+func reloadConfig(next Config) (restart []string) {
+	cur := currentConfig()
+	for _, f := range configFields {
+		if f.Reload || formatField(cur, f) == formatField(&next, f) {
+			continue
+		}
+		restart = append(restart, f.Flag)
+		_ = setField(&next, f, formatField(cur, f))
+	}
+	config.Store(&next)
+	return restart
+}
+
+// applyConfig переносит действующие настройки в части сервера,
+// которые хранят их у себя
+func applyConfig(level *slog.LevelVar, limits *rateLimiter) {
+	c := currentConfig()
+	lvl, _ := parseLevel(c.Log.Level) // проверено в validate
+	level.Set(lvl)
+	limits.setClasses(c.readClass(), c.writeClass())
+}
+
+// notifyHangup начинает принимать SIGHUP в канал для reloadOnHangup.
+// Вызывается до загрузки данных: без подписки SIGHUP завершает процесс,
+// а сигнал, пришедший во время загрузки, ждет в канале
+func notifyHangup() (hup <-chan os.Signal, stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	return ch, func() { signal.Stop(ch) }
+}
+
+// reloadOnHangup перечитывает настройки на каждый сигнал из hup (см.
+// notifyHangup), пока не отменен ctx
+func reloadOnHangup(ctx context.Context, hup <-chan os.Signal, loader *configLoader, level *slog.LevelVar, limits *rateLimiter) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		next, err := loader.load()
+		if err != nil {
+			log.Printf("reload config: %v (keeping the current configuration)", err)
+			continue
+		}
+		restart := reloadConfig(next)
+		applyConfig(level, limits)
+		if len(restart) > 0 {
+			log.Printf("reload config: restart required to change %s", strings.Join(restart, ", "))
+		}
+		log.Printf("reload config: done")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestConfig(t *testing.T) {
+	defer config.Store(currentConfig())
+	path := filepath.Join(t.TempDir(), "hw6.toml")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := map[string]string{}
+	load := func(args ...string) (Config, *configLoader, error) {
+		fs := flag.NewFlagSet("hw6", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		l, err := newConfigLoader(fs, args, func(k string) string { return env[k] })
+		if err != nil {
+			return Config{}, nil, err
+		}
+		c, err := l.load()
+		return c, l, err
+	}
+
+	// без файла по умолчанию - значения по умолчанию
+	c, _, err := load()
+	if err != nil || c != defaultConfig() {
+		t.Fatalf("defaults: %v %+v", err, c)
+	}
+
+	// файл < окружение < флаги
+	write("addr = \":9000\"\n[tasks]\nper_page = 10\nmax_per_page = 50\ntrash_retention = \"1h\"\n[rate]\nread_rate = 1\n")
+	env["HW6_CONFIG"] = path
+	env["HW6_TASKS_PER_PAGE"] = "20"
+	env["HW6_READ_RATE"] = "2"
+	c, _, err = load("-read-rate", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":9000" || c.Tasks.PerPage != 20 || c.Tasks.MaxPerPage != 50 ||
+		c.Tasks.TrashRetention != duration(time.Hour) || c.Rate.ReadRate != 3 || c.Rate.WriteRate != 5 {
+		t.Fatalf("layers: %+v", c)
+	}
+
+	// -print-config читается обратно в те же настройки
+	data, err := c.printConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Config
+	if err := toml.Unmarshal(data, &back); err != nil || back != c {
+		t.Fatalf("print-config: %v\n%s", err, data)
+	}
+
+	// ошибки: неизвестный ключ, неверные значения, нет явно заданного файла
+	write("[tasks]\nperpage = 1\n")
+	if _, _, err := load(); err == nil || !strings.Contains(err.Error(), "perpage") {
+		t.Fatalf("unknown key: %v", err)
+	}
+	write("")
+	if _, _, err := load("-tasks-per-page", "x"); err == nil {
+		t.Fatal("bad flag accepted")
+	}
+	env["HW6_TOKEN_TTL"] = "soon"
+	if _, _, err := load(); err == nil || !strings.Contains(err.Error(), "HW6_TOKEN_TTL") {
+		t.Fatalf("bad env: %v", err)
+	}
+	delete(env, "HW6_TOKEN_TTL")
+	_, _, err = load("-tasks-per-page", "0", "-log-format", "xml", "-log-level", "loud")
+	for _, want := range []string{"tasks.per_page", "log.format", "log level"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("validate: %v, want %s", err, want)
+		}
+	}
+	if _, _, err := load("-config", filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Fatal("missing config file accepted")
+	}
+
+	// перечитывание: на ходу меняется то, что можно, остальное - после перезапуска
+	_, l, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur := defaultConfig()
+	config.Store(&cur)
+	var level slog.LevelVar
+	limits := newRateLimiter(cur.readClass(), cur.writeClass())
+	write("addr = \":9000\"\n[tasks]\nper_page = 7\n[rate]\nwrite_rate = 0\nwrite_burst = 7\n[log]\nlevel = \"debug\"\n")
+	next, err := l.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart := reloadConfig(next)
+	applyConfig(&level, limits)
+	c = *currentConfig()
+	if len(restart) != 1 || restart[0] != "addr" || c.Addr != ":8080" || c.Tasks.PerPage != 20 {
+		t.Fatalf("reload: %v %+v", restart, c)
+	}
+	if level.Level() != slog.LevelDebug || limits.classes[rateWrite].Rate != 0 || limits.classes[rateRead].Rate == 0 || c.Rate.WriteBurst != 7 {
+		t.Fatalf("apply: %v %v", level.Level(), limits.classes)
+	}
+	delete(env, "HW6_TASKS_PER_PAGE")
+	next, _ = l.load()
+	reloadConfig(next)
+	if currentConfig().Tasks.PerPage != 7 {
+		t.Fatalf("per_page from file: %d", currentConfig().Tasks.PerPage)
+	}
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	defer config.Store(currentConfig())
+	path := filepath.Join(t.TempDir(), "hw6.toml")
+	if err := os.WriteFile(path, []byte("[tasks]\nper_page = 9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("hw6", flag.ContinueOnError)
+	l, err := newConfigLoader(fs, []string{"-config", path}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur := defaultConfig()
+	config.Store(&cur)
+
+	// SIGHUP "во время загрузки": процесс жив, сигнал ждет в канале
+	hup, stop := notifyHangup()
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var level slog.LevelVar
+		reloadOnHangup(ctx, hup, l, &level, nil)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); currentConfig().Tasks.PerPage != 9; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("config not reloaded")
+		}
+	}
+	cancel()
+	<-done
+}
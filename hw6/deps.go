@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Зависимости задач: BlockedBy - ID задач, которые нужно выполнить раньше.
+// Ребро "A blocked_by B" означает B -> A. Граф должен оставаться ацикличным,
+// а задачу нельзя выполнить (status=true), пока выполнены не все ее блокеры.
+// Задача в корзине (trash.go) никого не блокирует, но ребра от нее остаются
+// до окончательного удаления - на случай, если ее восстановят.
+
+// ошибки проверки зависимостей (ответ 409 Conflict, кроме errBadBlocker - 400)
+var (
+	errBadBlocker      = errors.New("invalid blocked_by")
+	errDependencyCycle = errors.New("dependency cycle")
+	errTaskBlocked     = errors.New("task is blocked")
+)
+
+// taskMap - задачи по ID (снимок хранилища для проверок)
+func taskMap(tasks []Task) map[string]Task {
+	m := make(map[string]Task, len(tasks))
+	for _, task := range tasks {
+		m[task.ID] = task
+	}
+	return m
+}
+
+// isBlocked - есть ли у задачи невыполненные блокеры
+func isBlocked(task Task, tasks map[string]Task) bool {
+	for _, id := range task.BlockedBy {
+		if blocker, ok := tasks[id]; ok && !blocker.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// markBlocked заполняет вычисляемое поле Blocked
+func markBlocked(list []Task, tasks map[string]Task) {
+	for i := range list {
+		list[i].Blocked = isBlocked(list[i], tasks)
+	}
+}
+
+// checkDependencies проверяет новую версию задачи task перед записью:
+// новые блокеры существуют, блокеры не повторяются, не создают цикл, и задачу
+// с открытыми блокерами нельзя отметить выполненной. tasks - текущие задачи
+// хранилища (старые блокеры могут быть в корзине - их не трогаем).
+func checkDependencies(task Task, tasks map[string]Task) error {
+	old := make(map[string]bool)
+	for _, id := range tasks[task.ID].BlockedBy {
+		old[id] = true
+	}
+	seen := make(map[string]bool, len(task.BlockedBy))
+	for _, id := range task.BlockedBy {
+		switch {
+		case id == task.ID:
+			return fmt.Errorf("%w: task cannot block itself", errBadBlocker)
+		case seen[id]:
+			return fmt.Errorf("%w: duplicate blocker %s", errBadBlocker, id)
+		}
+		if _, ok := tasks[id]; !ok && !old[id] {
+			return fmt.Errorf("%w: task %s not found", errBadBlocker, id)
+		}
+		seen[id] = true
+	}
+
+	// цикл: от блокеров по ребрам blocked_by можно дойти до самой задачи
+	if task.ID != "" {
+		if path := findPath(task.BlockedBy, task.ID, tasks); path != nil {
+			return fmt.Errorf("%w: %v", errDependencyCycle, append([]string{task.ID}, path...))
+		}
+	}
+
+	if task.Status {
+		// задача, которая уже была выполнена, остается выполненной
+		if old, ok := tasks[task.ID]; (!ok || !old.Status) && isBlocked(task, tasks) {
+			return fmt.Errorf("%w: open blockers must be done first", errTaskBlocked)
+		}
+	}
+	return nil
+}
+
+// findPath ищет путь по ребрам blocked_by от задач from до target (поиск в глубину)
+func findPath(from []string, target string, tasks map[string]Task) []string {
+	visited := make(map[string]bool)
+	var dfs func(id string) []string
+	dfs = func(id string) []string {
+		if id == target {
+			return []string{id}
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		for _, next := range tasks[id].BlockedBy {
+			if path := dfs(next); path != nil {
+				return append([]string{id}, path...)
+			}
+		}
+		return nil
+	}
+	for _, id := range from {
+		if path := dfs(id); path != nil {
+			return path
+		}
+	}
+	return nil
+}
+
+// dependencyError отправляет ответ на ошибку проверки зависимостей
+func dependencyError(c *gin.Context, err error) {
+	c.JSON(dependencyStatus(err), gin.H{"error": err.Error()})
+}
+
+// dependencyStatus - код ответа на ошибку проверки зависимостей
+func dependencyStatus(err error) int {
+	if errors.Is(err, errBadBlocker) {
+		return http.StatusBadRequest
+	}
+	return http.StatusConflict
+}
+
+// unblockChanges - изменения, которые убирают окончательно удаляемые задачи
+// ids из blocked_by остальных задач от имени actor (вызывается под h.mu)
+func (h *handlers) unblockChanges(ids []string, actor string) []change {
+	var changes []change
+	for _, task := range h.store.List() {
+		n := len(task.BlockedBy)
+		for _, id := range ids {
+			task.BlockedBy = without(task.BlockedBy, id)
+		}
+		if len(task.BlockedBy) == n {
+			continue
+		}
+		task.UpdatedBy = actor
+		changes = append(changes, change{Op: opUpdate, Task: task})
+	}
+	return changes
+}
+
+// without возвращает копию списка без id
+func without(list []string, id string) []string {
+	out := make([]string, 0, len(list))
+	for _, x := range list {
+		if x != id {
+			out = append(out, x)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// graphNode, graphEdge - ответ GET /task/:id/graph
+type graphNode struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Status  bool   `json:"status"`
+	Blocked bool   `json:"blocked"`
+}
+
+type graphEdge struct {
+	From string `json:"from"` // блокер
+	To   string `json:"to"`   // задача, которую он блокирует
+}
+
+// обработчик запроса GET /task/:id/graph - граф зависимостей задачи:
+// upstream - все задачи, от которых она зависит (транзитивно),
+// downstream - все задачи, которые зависят от нее
+func (h *handlers) taskGraph(c *gin.Context) {
+	// в графе только задачи, которые видит автор запроса
+	tasks := taskMap(h.visibleTasks(principalFrom(c)))
+	root, ok := tasks[c.Param("id")]
+	if !ok {
+		storeError(c, errTaskNotFound)
+		return
+	}
+
+	// обратные ребра: блокер -> задачи, которые он блокирует
+	dependents := make(map[string][]string)
+	for _, task := range tasks {
+		for _, id := range task.BlockedBy {
+			dependents[id] = append(dependents[id], task.ID)
+		}
+	}
+
+	walk := func(start string, next func(id string) []string) []string {
+		visited := map[string]bool{start: true}
+		queue := []string{start}
+		var found []string
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, n := range next(id) {
+				if _, ok := tasks[n]; ok && !visited[n] {
+					visited[n] = true
+					found = append(found, n)
+					queue = append(queue, n)
+				}
+			}
+		}
+		sort.Strings(found)
+		return found
+	}
+	upstream := walk(root.ID, func(id string) []string { return tasks[id].BlockedBy })
+	downstream := walk(root.ID, func(id string) []string { return dependents[id] })
+
+	ids := append(append([]string{root.ID}, upstream...), downstream...)
+	list := make([]Task, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, tasks[id])
+	}
+	// blocked - как в GET /task/:id: по всем блокерам, в том числе невидимым
+	markBlocked(list, h.blockers(list))
+	inGraph := make(map[string]bool, len(ids))
+	nodes := make([]graphNode, 0, len(ids))
+	for _, task := range list {
+		inGraph[task.ID] = true
+		nodes = append(nodes, graphNode{ID: task.ID, Title: task.Title, Status: task.Status, Blocked: task.Blocked})
+	}
+	edges := []graphEdge{}
+	for _, id := range ids {
+		for _, blocker := range tasks[id].BlockedBy {
+			if inGraph[blocker] {
+				edges = append(edges, graphEdge{From: blocker, To: id})
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"task":       root.ID,
+		"upstream":   upstream,
+		"downstream": downstream,
+		"nodes":      nodes,
+		"edges":      edges,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	// c blocked_by b blocked_by a; d выполнена; e - только в старых блокерах f
+	tasks := taskMap([]Task{
+		{ID: "a"},
+		{ID: "b", BlockedBy: []string{"a"}},
+		{ID: "c", BlockedBy: []string{"b"}},
+		{ID: "d", Status: true},
+		{ID: "f", BlockedBy: []string{"e"}, Status: true},
+	})
+	for _, tc := range []struct {
+		name string
+		task Task
+		err  error
+		msg  string
+	}{
+		{"new task", Task{BlockedBy: []string{"a", "c"}}, nil, ""},
+		{"self", Task{ID: "a", BlockedBy: []string{"a"}}, errBadBlocker, "cannot block itself"},
+		{"duplicate", Task{ID: "c", BlockedBy: []string{"b", "b"}}, errBadBlocker, "duplicate blocker b"},
+		{"missing", Task{ID: "c", BlockedBy: []string{"x"}}, errBadBlocker, "task x not found"},
+		{"old blocker in trash", Task{ID: "f", BlockedBy: []string{"e"}, Status: true}, nil, ""},
+		{"direct cycle", Task{ID: "a", BlockedBy: []string{"b"}}, errDependencyCycle, "[a b a]"},
+		{"indirect cycle", Task{ID: "a", BlockedBy: []string{"d", "c"}}, errDependencyCycle, "[a c b a]"},
+		{"no cycle", Task{ID: "c", BlockedBy: []string{"a", "b"}}, nil, ""},
+		{"blocked", Task{ID: "c", BlockedBy: []string{"b"}, Status: true}, errTaskBlocked, ""},
+		{"done blocker", Task{ID: "a", BlockedBy: []string{"d"}, Status: true}, nil, ""},
+		// выполненная задача не перестает быть выполненной из-за блокера
+		{"already done", Task{ID: "d", BlockedBy: []string{"a"}, Status: true}, nil, ""},
+	} {
+		err := checkDependencies(tc.task, tasks)
+		if tc.err == nil && err != nil || !errors.Is(err, tc.err) || err != nil && !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%s: %v, want %v %q", tc.name, err, tc.err, tc.msg)
+		}
+	}
+}
+
+func TestDependencyCycles(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		s.ok("", http.MethodPost, "/task", Task{Title: title}, nil)
+	}
+	tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil))
+	a, b, c, d, e := tasks[0], tasks[1], tasks[2], tasks[3], tasks[4]
+
+	// c blocked_by b blocked_by a
+	s.ok("", http.MethodPatch, "/task/"+b.ID, map[string]any{"blocked_by": []string{a.ID}}, nil)
+	s.ok("", http.MethodPatch, "/task/"+c.ID, map[string]any{"blocked_by": []string{b.ID}}, nil)
+
+	check := func(name string, w *httptest.ResponseRecorder, want int) {
+		t.Helper()
+		if w.Code != want {
+			t.Errorf("%s: %d %s, want %d", name, w.Code, w.Body, want)
+		}
+	}
+	check("self by patch", s.do("", http.MethodPatch, "/task/"+a.ID, map[string]any{"blocked_by": []string{a.ID}}), http.StatusBadRequest)
+	check("cycle by patch", s.do("", http.MethodPatch, "/task/"+a.ID, map[string]any{"blocked_by": []string{c.ID}}), http.StatusConflict)
+	check("cycle by json patch", s.do("", http.MethodPatch, "/task/"+a.ID,
+		[]map[string]any{{"op": "add", "path": "/blocked_by/-", "value": c.ID}}, "Content-Type", jsonPatchType), http.StatusConflict)
+	check("cycle by put", s.do("", http.MethodPut, "/task/"+a.ID, map[string]any{"title": "a", "blocked_by": []string{b.ID}}), http.StatusConflict)
+
+	// в пакете каждая операция видит предыдущие: цикл из двух операций
+	// отклоняется, и не применяется ни одна
+	w := s.do("", http.MethodPost, "/tasks/batch", map[string]any{"operations": []map[string]any{
+		{"op": "patch", "id": d.ID, "task": map[string]any{"blocked_by": []string{e.ID}}},
+		{"op": "update", "id": e.ID, "task": map[string]any{"title": "e", "blocked_by": []string{c.ID, d.ID}}},
+	}})
+	var res struct {
+		Applied bool          `json:"applied"`
+		Results []batchResult `json:"results"`
+	}
+	decodeBody(t, w, &res)
+	if w.Code != http.StatusConflict || res.Applied || res.Results[1].Status != http.StatusConflict ||
+		!strings.Contains(res.Results[1].Error, errDependencyCycle.Error()) {
+		t.Fatalf("batch cycle: %d %s", w.Code, w.Body)
+	}
+	check("batch self", s.do("", http.MethodPost, "/tasks/batch", map[string]any{"operations": []map[string]any{
+		{"op": "patch", "id": d.ID, "task": map[string]any{"blocked_by": []string{d.ID}}},
+	}}), http.StatusBadRequest)
+
+	// ничего из отклоненного не записано
+	for _, task := range decodeTasks(t, s.do("", http.MethodGet, "/all", nil)) {
+		if task.ID == a.ID || task.ID == d.ID || task.ID == e.ID {
+			if len(task.BlockedBy) != 0 {
+				t.Errorf("%s changed: %+v", task.Title, task)
+			}
+		}
+	}
+}
+
+func TestGraphBlocked(t *testing.T) {
+	s := newTestServer(t, nil, apiKey{Name: "alice"}, apiKey{Name: "bob"})
+	s.ok("bob", http.MethodPost, "/task", Task{Title: "bob's", SharedWith: []string{"alice"}}, nil)
+	s.ok("alice", http.MethodPost, "/task", Task{Title: "alice's"}, nil)
+	bobs := decodeTasks(t, s.do("bob", http.MethodGet, "/all", nil))[0]
+	var alices Task
+	for _, task := range decodeTasks(t, s.do("alice", http.MethodGet, "/all", nil)) {
+		if task.Title == "alice's" {
+			alices = task
+		}
+	}
+	s.ok("alice", http.MethodPatch, "/task/"+alices.ID, map[string]any{"blocked_by": []string{bobs.ID}}, nil)
+	// блокер больше не виден alice, но задачу все так же блокирует
+	s.ok("bob", http.MethodPatch, "/task/"+bobs.ID, map[string]any{"shared_with": nil}, nil)
+
+	var task Task
+	s.ok("alice", http.MethodGet, "/task/"+alices.ID, nil, &task)
+	var graph struct {
+		Nodes []graphNode `json:"nodes"`
+	}
+	s.ok("alice", http.MethodGet, "/task/"+alices.ID+"/graph", nil, &graph)
+	if !task.Blocked || len(graph.Nodes) != 1 || !graph.Nodes[0].Blocked {
+		t.Fatalf("task blocked %v, graph %+v", task.Blocked, graph.Nodes)
+	}
+}
+
+func TestPurgeBlockers(t *testing.T) {
+	dir := t.TempDir()
+	store, _, err := newWALStore(filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := newTestServer(t, store)
+	for _, title := range []string{"a", "b", "c", "d"} {
+		s.ok("", http.MethodPost, "/task", Task{Title: title}, nil)
+	}
+	tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil))
+	a, b, c, d := tasks[0], tasks[1], tasks[2], tasks[3]
+	s.ok("", http.MethodPatch, "/task/"+c.ID, map[string]any{"blocked_by": []string{a.ID, b.ID}}, nil)
+	s.ok("", http.MethodPatch, "/task/"+d.ID, map[string]any{"blocked_by": []string{a.ID}}, nil)
+	s.ok("", http.MethodDelete, "/tasks/"+a.ID, nil, nil)
+	s.ok("", http.MethodDelete, "/tasks/"+b.ID, nil, nil)
+
+	// удаление и правка blocked_by зависимых задач - одна запись журнала
+	records := store.records
+	s.ok("", http.MethodDelete, "/trash/"+a.ID, nil, nil)
+	if store.records != records+1 {
+		t.Fatalf("purge wrote %d records", store.records-records)
+	}
+	got, _ := store.Get(c.ID)
+	if len(got.BlockedBy) != 1 || got.BlockedBy[0] != b.ID {
+		t.Fatalf("c blocked_by %v", got.BlockedBy)
+	}
+	if got, _ := store.Get(d.ID); len(got.BlockedBy) != 0 {
+		t.Fatalf("d blocked_by %v", got.BlockedBy)
+	}
+
+	s.ok("", http.MethodDelete, "/trash", nil, nil)
+	if store.records != records+2 || len(store.Trash()) != 0 {
+		t.Fatalf("empty trash: %d records, trash %+v", store.records-records, store.Trash())
+	}
+	if got, _ := store.Get(c.ID); len(got.BlockedBy) != 0 {
+		t.Fatalf("c blocked_by %v", got.BlockedBy)
+	}
+}
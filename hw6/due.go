@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Запросы по сроку выполнения (GET /all):
+//
+//	?due=overdue       - срок прошел, а задача не выполнена
+//	?due=today         - срок сегодня
+//	?due_within=N      - срок от текущего момента до конца N-го дня (0 - до конца сегодня)
+//	?tz=Europe/Moscow  - часовой пояс, в котором считаются "сегодня" и дни (по умолчанию UTC)
+//
+// Условия объединяются с ?filter= через and.
+
+// dueNode - условие фильтра по сроку выполнения на интервале [from, to)
+type dueNode struct {
+	name     string
+	from, to time.Time
+	open     bool // только невыполненные задачи
+}
+
+func (n dueNode) eval(task Task) bool {
+	if task.DueAt == nil || (n.open && task.Status) {
+		return false
+	}
+	return !task.DueAt.Before(n.from) && task.DueAt.Before(n.to)
+}
+
+func (n dueNode) String() string {
+	return n.name
+}
+
+// startOfDay - полночь дня t в его часовом поясе
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// dueFromQuery собирает условия по сроку из ?due=, ?due_within= и ?tz=
+// относительно момента now; nil - условий нет
+func dueFromQuery(query url.Values, now time.Time) ([]filterNode, error) {
+	loc := time.UTC
+	if query.Has("tz") {
+		var err error
+		loc, err = time.LoadLocation(query.Get("tz"))
+		if err != nil {
+			return nil, fmt.Errorf("tz: unknown time zone %q", query.Get("tz"))
+		}
+	}
+	now = now.In(loc)
+	today := startOfDay(now)
+
+	var nodes []filterNode
+	if query.Has("due") {
+		switch query.Get("due") {
+		case "overdue":
+			nodes = append(nodes, dueNode{name: "due=overdue", to: now, open: true})
+		case "today":
+			nodes = append(nodes, dueNode{name: "due=today", from: today, to: today.AddDate(0, 0, 1)})
+		default:
+			return nil, fmt.Errorf("due must be overdue or today, got %q", query.Get("due"))
+		}
+	}
+	if query.Has("due_within") {
+		days, err := strconv.Atoi(query.Get("due_within"))
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("due_within must be a number of days, got %q", query.Get("due_within"))
+		}
+		nodes = append(nodes, dueNode{
+			name: "due_within=" + strconv.Itoa(days),
+			from: now,
+			to:   today.AddDate(0, 0, days+1),
+		})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dueIDs - ID задач, подходящих под условия по сроку из query на момент now
+func dueIDs(t *testing.T, query string, now time.Time, tasks []Task) string {
+	t.Helper()
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := filterFromQuery(q, now)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	var ids []string
+	for _, task := range tasks {
+		if n.eval(task) {
+			ids = append(ids, task.ID)
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestDueWindows(t *testing.T) {
+	at := func(s string) *time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	// 22:30 по UTC - это уже 01:30 следующего дня в Москве
+	now := *at("2026-03-10T22:30:00Z")
+	tasks := []Task{
+		{ID: "a", DueAt: at("2026-03-10T12:00:00Z")},
+		{ID: "b", DueAt: at("2026-03-10T12:00:00Z"), Status: true},
+		{ID: "c", DueAt: at("2026-03-10T23:00:00Z")},
+		{ID: "d", DueAt: at("2026-03-11T20:00:00Z")},
+		{ID: "e", DueAt: at("2026-03-11T22:00:00Z")},
+		{ID: "f"},
+		{ID: "g", DueAt: &now},
+	}
+	for _, tc := range []struct{ query, want string }{
+		// срок ровно сейчас еще не прошел; выполненные не просрочены
+		{"due=overdue", "a"},
+		{"due=today", "a,b,c,g"},
+		{"due_within=0", "c,g"},
+		{"due_within=1", "c,d,e,g"},
+		{"due=overdue&due_within=1", ""},
+		{"due=today&status=false", "a,c,g"},
+		// "сегодня" и границы дней - в часовом поясе ?tz=
+		{"due=overdue&tz=Europe/Moscow", "a"},
+		{"due=today&tz=Europe/Moscow", "c,d,g"},
+		{"due_within=0&tz=Europe/Moscow", "c,d,g"},
+		{"due_within=1&tz=Europe/Moscow", "c,d,e,g"},
+		{"due=today&tz=America/Los_Angeles", "a,b,c,g"},
+	} {
+		if got := dueIDs(t, tc.query, now, tasks); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.query, got, tc.want)
+		}
+	}
+
+	// 8 марта 2026 в Нью-Йорке переводят часы: в этом дне 23 часа
+	now = *at("2026-03-08T12:00:00Z")
+	tasks = []Task{
+		{ID: "a", DueAt: at("2026-03-08T04:30:00Z")}, // 7 марта, 23:30 EST
+		{ID: "b", DueAt: at("2026-03-08T05:30:00Z")}, // 8 марта, 00:30 EST
+		{ID: "c", DueAt: at("2026-03-09T03:30:00Z")}, // 8 марта, 23:30 EDT
+		{ID: "d", DueAt: at("2026-03-09T04:30:00Z")}, // 9 марта, 00:30 EDT
+	}
+	if got := dueIDs(t, "due=today&tz=America/New_York", now, tasks); got != "b,c" {
+		t.Errorf("today across DST: %s", got)
+	}
+}
+
+func TestDueQuery(t *testing.T) {
+	s := newTestServer(t, nil)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	s.ok("", http.MethodPost, "/task", Task{Title: "просрочена", DueAt: &yesterday}, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "без срока"}, nil)
+
+	w := s.do("", http.MethodGet, "/all?due=overdue", nil)
+	tasks := decodeTasks(t, w)
+	if len(tasks) != 1 || tasks[0].Title != "просрочена" {
+		t.Fatalf("overdue: %+v", tasks)
+	}
+	// ответ зависит от времени и не кэшируется
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" || w.Header().Get("ETag") != "" {
+		t.Fatalf("Cache-Control = %q, ETag = %q", cc, w.Header().Get("ETag"))
+	}
+
+	for _, query := range []string{"due=soon", "due=", "due_within=-1", "due_within=x", "due=today&tz=Mars/Olympus"} {
+		if w := s.do("", http.MethodGet, "/all?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Выгрузка и загрузка задач в других форматах (GET /export, POST /import):
+//
+//	csv     - таблица с заголовком, колонки exportColumns
+//	ndjson  - по одной задаче JSON в строке
+//	yaml    - список задач
+//	md      - таблица Markdown (те же колонки, что и csv)
+//
+// Выгрузку можно снова загрузить: поля, которые задает сервер (seq, version,
+// время и т.д.), при загрузке пропускаются.
+
+// форматы выгрузки и их Content-Type
+var exportTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"yaml":   "application/yaml",
+	"md":     "text/markdown; charset=utf-8",
+}
+
+// exportColumns - колонки csv и md
+var exportColumns = []string{
+	"id", "title", "description", "status", "priority", "due_at", "blocked_by",
+	"created_at", "updated_at", "completed_at", "updated_by", "owner", "shared_with",
+}
+
+// taskCells - значения колонок exportColumns задачи
+// (blocked_by и shared_with - через пробел, время - RFC 3339)
+func taskCells(task Task) []string {
+	return []string{
+		task.ID, task.Title, task.Description, strconv.FormatBool(task.Status),
+		strconv.Itoa(int(task.Priority)), formatTime(task.DueAt), strings.Join(task.BlockedBy, " "),
+		formatTime(task.CreatedAt), formatTime(task.UpdatedAt), formatTime(task.CompletedAt), task.UpdatedBy,
+		task.Owner, strings.Join(task.SharedWith, " "),
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// writeTasks записывает задачи в формате format
+func writeTasks(w io.Writer, format string, tasks []Task) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(exportColumns)
+		for _, task := range tasks {
+			_ = cw.Write(taskCells(task))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, task := range tasks {
+			err := enc.Encode(task)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		return writeYAML(w, tasks)
+	case "md":
+		return writeMarkdown(w, tasks)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// writeYAML записывает задачи в YAML с теми же именами и порядком полей,
+// что и в JSON: JSON - это YAML в "плоском" стиле, его остается
+// разобрать и вывести в обычном блочном стиле
+func writeYAML(w io.Writer, tasks []Task) error {
+	data, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	blockStyle(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// blockStyle сбрасывает стиль узлов YAML (кавычки, {}, []) на стиль по умолчанию
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		blockStyle(child)
+	}
+}
+
+// writeMarkdown записывает задачи таблицей Markdown
+func writeMarkdown(w io.Writer, tasks []Task) error {
+	var buf bytes.Buffer
+	writeRow := func(cells []string) {
+		buf.WriteString("|")
+		for _, cell := range cells {
+			buf.WriteString(" " + mdEscape(cell) + " |")
+		}
+		buf.WriteString("\n")
+	}
+	writeRow(exportColumns)
+	buf.WriteString(strings.Repeat("| --- ", len(exportColumns)) + "|\n")
+	for _, task := range tasks {
+		writeRow(taskCells(task))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// mdEscape экранирует ячейку таблицы Markdown: "|" -> "\|", перевод строки -> <br>
+func mdEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	s = strings.ReplaceAll(s, "\r\n", "<br>")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+// обработчик запроса GET /export?format=csv|ndjson|yaml|md
+// (задачи можно отобрать теми же параметрами, что и в GET /all)
+func (h *handlers) exportTasks(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := exportTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ndjson, yaml or md"})
+		return
+	}
+	filter, err := filterFromQuery(c.Request.URL.Query(), time.Now())
+	if err != nil {
+		filterQueryError(c, err)
+		return
+	}
+
+	tasks := h.visibleTasks(principalFrom(c))
+	selected := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		if filter == nil || filter.eval(task) {
+			selected = append(selected, task)
+		}
+	}
+	markBlocked(selected, h.blockers(selected))
+	var buf bytes.Buffer
+	err = writeTasks(&buf, format, selected)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="tasks.`+format+`"`)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Язык фильтров для GET /all?filter=
+//
+//	expr       = or
+//	or         = and { ("or" | "||") and }
+//	and        = unary { ("and" | "&&") unary }
+//	unary      = ("not" | "!") unary | "(" expr ")" | comparison
+//	comparison = field op value
+//	field      = id | title | description | status | priority
+//	op         = "=" | "==" | "!=" | "<" | "<=" | ">" | ">=" | "~"
+//	value      = число | true | false | строка в кавычках '...' или "..." | слово
+//
+// "~" - поиск подстроки без учета регистра (только title, description, id).
+// Пример: status=false and (priority>=3 or title~"срочно")
+
+// filterError - ошибка разбора фильтра с позицией (номер символа, с 1)
+type filterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *filterError) Error() string {
+	return fmt.Sprintf("filter: position %d: %s", e.Pos, e.Msg)
+}
+
+// filterNode - узел дерева (AST) фильтра
+type filterNode interface {
+	eval(task Task) bool
+	String() string
+}
+
+// andNode, orNode - логические операции над двумя узлами
+type andNode struct{ left, right filterNode }
+type orNode struct{ left, right filterNode }
+
+// notNode - отрицание
+type notNode struct{ x filterNode }
+
+// cmpNode - сравнение поля задачи со значением
+type cmpNode struct {
+	pos   int
+	field string
+	op    string
+	str   string // значение для строковых полей
+	num   int    // значение для priority
+	bool  bool   // значение для status
+}
+
+func (n andNode) eval(task Task) bool { return n.left.eval(task) && n.right.eval(task) }
+func (n orNode) eval(task Task) bool  { return n.left.eval(task) || n.right.eval(task) }
+func (n notNode) eval(task Task) bool { return !n.x.eval(task) }
+
+func (n andNode) String() string { return "(" + n.left.String() + " and " + n.right.String() + ")" }
+func (n orNode) String() string  { return "(" + n.left.String() + " or " + n.right.String() + ")" }
+func (n notNode) String() string { return "not " + n.x.String() }
+
+func (n cmpNode) String() string {
+	switch n.field {
+	case "priority":
+		return n.field + n.op + strconv.Itoa(n.num)
+	case "status":
+		return n.field + n.op + strconv.FormatBool(n.bool)
+	}
+	return n.field + n.op + strconv.Quote(n.str)
+}
+
+func (n cmpNode) eval(task Task) bool {
+	switch n.field {
+	case "priority":
+		return compareInts(int(task.Priority), n.op, n.num)
+	case "status":
+		if n.op == "!=" {
+			return task.Status != n.bool
+		}
+		return task.Status == n.bool
+	}
+
+	var value string
+	switch n.field {
+	case "id":
+		value = task.ID
+	case "title":
+		value = task.Title
+	case "description":
+		value = task.Description
+	}
+	switch n.op {
+	case "~":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(n.str))
+	case "!=":
+		return value != n.str
+	}
+	return value == n.str
+}
+
+func compareInts(a int, op string, b int) bool {
+	switch op {
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return a == b
+}
+
+// допустимые операции для каждого поля
+var filterFields = map[string]string{
+	"id":          "= == != ~",
+	"title":       "= == != ~",
+	"description": "= == != ~",
+	"status":      "= == !=",
+	"priority":    "= == != < <= > >=",
+}
+
+// виды лексем
+const (
+	tokEOF = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// lexFilter разбивает строку фильтра на лексемы
+func lexFilter(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", pos})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j == len(runes) {
+				return nil, &filterError{pos, "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, string(runes[i+1 : j]), pos})
+			i = j + 1
+		case strings.ContainsRune("=!<>~&|", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=&|", runes[j]) {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "&&":
+				tokens = append(tokens, token{tokAnd, op, pos})
+			case "||":
+				tokens = append(tokens, token{tokOr, op, pos})
+			case "!":
+				tokens = append(tokens, token{tokNot, op, pos})
+			case "=", "==", "!=", "<", "<=", ">", ">=", "~":
+				tokens = append(tokens, token{tokOp, op, pos})
+			default:
+				return nil, &filterError{pos, fmt.Sprintf("unknown operator %q", op)}
+			}
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()=!<>~&|\"'", runes[j]) {
+				j++
+			}
+			word := string(runes[i:j])
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, token{tokAnd, word, pos})
+			case "or":
+				tokens = append(tokens, token{tokOr, word, pos})
+			case "not":
+				tokens = append(tokens, token{tokNot, word, pos})
+			default:
+				tokens = append(tokens, token{tokWord, word, pos})
+			}
+			i = j
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes) + 1}), nil
+}
+
+// filterParser - разбор рекурсивным спуском
+type filterParser struct {
+	tokens []token
+	i      int
+}
+
+func (p *filterParser) peek() token { return p.tokens[p.i] }
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// parseFilter разбирает строку фильтра в дерево
+func parseFilter(src string) (filterNode, error) {
+	tokens, err := lexFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, &filterError{1, "empty filter"}
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &filterError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return node, nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNot:
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, &filterError{r.pos, "expected )"}
+		}
+		return x, nil
+	case tokWord:
+		return p.parseComparison(t)
+	case tokEOF:
+		return nil, &filterError{t.pos, "unexpected end of filter"}
+	}
+	return nil, &filterError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+}
+
+func (p *filterParser) parseComparison(field token) (filterNode, error) {
+	name := strings.ToLower(field.text)
+	ops, ok := filterFields[name]
+	if !ok {
+		return nil, &filterError{field.pos, fmt.Sprintf("unknown field %q", field.text)}
+	}
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, &filterError{op.pos, "expected comparison operator after " + field.text}
+	}
+	if !strings.Contains(" "+ops+" ", " "+op.text+" ") {
+		return nil, &filterError{op.pos, fmt.Sprintf("operator %s is not allowed for %s", op.text, name)}
+	}
+	value := p.next()
+	if value.kind != tokWord && value.kind != tokString {
+		return nil, &filterError{value.pos, "expected value after " + op.text}
+	}
+	return newCmpNode(field.pos, name, op.text, value)
+}
+
+// newCmpNode проверяет тип значения для поля
+func newCmpNode(pos int, field, op string, value token) (filterNode, error) {
+	n := cmpNode{pos: pos, field: field, op: op, str: value.text}
+	var err error
+	switch field {
+	case "priority":
+		n.num, err = strconv.Atoi(value.text)
+		if err != nil || n.num < 0 || n.num > 255 {
+			return nil, &filterError{value.pos, fmt.Sprintf("priority must be a number 0..255, got %q", value.text)}
+		}
+	case "status":
+		n.bool, err = strconv.ParseBool(value.text)
+		if err != nil {
+			return nil, &filterError{value.pos, fmt.Sprintf("status must be true or false, got %q", value.text)}
+		}
+	}
+	return n, nil
+}
+
+// filterFromQuery собирает фильтр из ?filter=, сокращений ?status= и ?priority=
+// и условий по сроку (см. due.go) на момент now;
+// все условия объединяются через and; nil - фильтра нет
+func filterFromQuery(query url.Values, now time.Time) (filterNode, error) {
+	var node filterNode
+	and := func(n filterNode) {
+		if node == nil {
+			node = n
+			return
+		}
+		node = andNode{node, n}
+	}
+
+	if query.Has("filter") {
+		n, err := parseFilter(query.Get("filter"))
+		if err != nil {
+			return nil, err
+		}
+		and(n)
+	}
+	// сокращения: status=true эквивалентно filter=status=true
+	for _, field := range []string{"status", "priority"} {
+		if !query.Has(field) {
+			continue
+		}
+		n, err := newCmpNode(1, field, "=", token{tokWord, query.Get(field), 1})
+		if err != nil {
+			return nil, errors.New(err.(*filterError).Msg)
+		}
+		and(n)
+	}
+	due, err := dueFromQuery(query, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range due {
+		and(n)
+	}
+	return node, nil
+}
+
+// filterQueryError отвечает 400 на ошибку filterFromQuery
+// (для ошибки в выражении - с позицией в нем)
+func filterQueryError(c *gin.Context, err error) {
+	var ferr *filterError
+	if errors.As(err, &ferr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ferr.Msg, "position": ferr.Pos})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, tc := range []struct{ src, want string }{
+		// and связывает сильнее or, not - сильнее and
+		{`status=true or priority>1 and title~a`, `(status=true or (priority>1 and title~"a"))`},
+		{`status=true and priority>1 or title~a`, `((status=true and priority>1) or title~"a")`},
+		{`not status=true and priority=2`, `(not status=true and priority=2)`},
+		{`! (status=true || priority<=3) && id!=x`, `(not (status=true or priority<=3) and id!="x")`},
+		{`(status=true or priority>1) and title~a`, `((status=true or priority>1) and title~"a")`},
+		// ключевые слова и поля без учета регистра, == - то же, что =
+		{`Title=="x" AND NOT Status=false`, `(title=="x" and not status=false)`},
+		// кавычки: пробелы, операторы и кавычки другого вида внутри строки
+		{`title="a and b"`, `title="a and b"`},
+		{`title='say "hi"'`, `title="say \"hi\""`},
+		{`description="it's (ok) <=~"`, `description="it's (ok) <=~"`},
+		{`title="back\slash"`, `title="back\\slash"`},
+		{`title=''`, `title=""`},
+	} {
+		n, err := parseFilter(tc.src)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tc.src, err)
+			continue
+		}
+		if got := n.String(); got != tc.want {
+			t.Errorf("parseFilter(%q) = %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{``, 1, "empty filter"},
+		{`owner=bob`, 1, `unknown field "owner"`},
+		{`status=true and a=1`, 17, `unknown field "a"`},
+		{`title="abc`, 7, "unterminated string"},
+		{`title=abc and`, 14, "unexpected end of filter"},
+		{`(status=true`, 13, "expected )"},
+		{`status=true)`, 12, `unexpected ")"`},
+		{`priority~3`, 9, "operator ~ is not allowed for priority"},
+		{`status<true`, 7, "operator < is not allowed for status"},
+		{`priority=300`, 10, "priority must be a number 0..255"},
+		{`priority=-1`, 10, "priority must be a number 0..255"},
+		{`status=maybe`, 8, "status must be true or false"},
+		{`title`, 6, "expected comparison operator after title"},
+		{`title=`, 7, "expected value after ="},
+		{`title=a & b`, 9, `unknown operator "&"`},
+		{`and title=a`, 1, `unexpected "and"`},
+		// позиция - в символах, а не байтах
+		{`title="срочно" or x=1`, 19, `unknown field "x"`},
+	} {
+		_, err := parseFilter(tc.src)
+		var ferr *filterError
+		if !errors.As(err, &ferr) {
+			t.Errorf("parseFilter(%q) error = %v, want filterError", tc.src, err)
+			continue
+		}
+		if ferr.Pos != tc.pos || !strings.Contains(ferr.Msg, tc.msg) {
+			t.Errorf("parseFilter(%q) = position %d %q, want %d %q", tc.src, ferr.Pos, ferr.Msg, tc.pos, tc.msg)
+		}
+	}
+}
+
+func TestFilterEval(t *testing.T) {
+	tasks := []Task{
+		{ID: "1", Title: "Срочно позвонить", Priority: 5},
+		{ID: "2", Title: "Купить хлеб", Description: "и молоко", Status: true, Priority: 1},
+		{ID: "3", Title: "Отчет", Priority: 3},
+	}
+	for _, tc := range []struct{ src, want string }{
+		{`status=false and (priority>=3 or title~"срочно")`, "1,3"},
+		{`title~СРОЧНО`, "1"},
+		{`description~молоко or id=3`, "2,3"},
+		{`not status=true and priority<5`, "3"},
+		{`priority!=3 and status!=true`, "1"},
+	} {
+		n, err := parseFilter(tc.src)
+		if err != nil {
+			t.Fatalf("parseFilter(%q): %v", tc.src, err)
+		}
+		var ids []string
+		for _, task := range tasks {
+			if n.eval(task) {
+				ids = append(ids, task.ID)
+			}
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestFilterFromQuery(t *testing.T) {
+	q := url.Values{"filter": {"priority>1"}, "status": {"false"}}
+	n, err := filterFromQuery(q, time.Now())
+	if err != nil || n.String() != "(priority>1 and status=false)" {
+		t.Fatalf("filterFromQuery = %v, %v", n, err)
+	}
+	// ошибки сокращений - без позиции
+	_, err = filterFromQuery(url.Values{"priority": {"high"}}, time.Now())
+	var ferr *filterError
+	if err == nil || errors.As(err, &ferr) {
+		t.Fatalf("priority=high: %v", err)
+	}
+	if n, err := filterFromQuery(url.Values{}, time.Now()); n != nil || err != nil {
+		t.Fatalf("no filter: %v, %v", n, err)
+	}
+}
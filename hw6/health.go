@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Проверки для оркестратора и плавная остановка.
+//
+//	GET /healthz - процесс жив: 200, пока сервер вообще отвечает
+//	GET /readyz  - сервер готов к запросам: задачи загружены, сервер не
+//	               останавливается и каталоги хранилищ доступны для записи;
+//	               иначе 503 с причиной
+//
+// Порт открывается до загрузки задач: пока они читаются, сервер отвечает
+// только на проверки, на остальное - 503 (startingRouter).
+//
+// Запись в каталоги хранилищ проверяется не чаще раза в writableCheckEvery:
+// оркестратор опрашивает /readyz часто, и каждый раз создавать файл незачем.
+//
+// По SIGINT/SIGTERM /readyz начинает отвечать 503; через -drain-delay
+// (балансировщик успевает убрать сервер из списка) сервер перестает
+// принимать соединения и ждет завершения начатых запросов (не дольше
+// -shutdown-timeout); потоки событий (/events/stream) при этом закрываются.
+// Затем останавливаются фоновые задачи, а хранилища сбрасываются на диск
+// (снимок WAL, история) - см. main.
+
+// server - HTTP-сервер, обработчик которого подменяется после загрузки задач
+type server struct {
+	*http.Server
+	router atomic.Pointer[gin.Engine]
+}
+
+// newServer создает сервер на addr, который пока отвечает только на проверки
+func newServer(addr string) *server {
+	s := &server{}
+	s.router.Store(startingRouter())
+	// контекст запросов отменяется при остановке: так заканчиваются потоки
+	// событий, которые иначе не дали бы серверу остановиться
+	base, cancel := context.WithCancel(context.Background())
+	s.Server = &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	s.RegisterOnShutdown(cancel)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.Load().ServeHTTP(w, r)
+}
+
+// setRouter начинает обслуживать запросы маршрутами r
+func (s *server) setRouter(r *gin.Engine) {
+	s.router.Store(r)
+}
+
+// shutdown плавно останавливает сервер, ожидая запросы не дольше timeout
+func (s *server) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// startingRouter - маршруты на время загрузки задач
+func startingRouter() *gin.Engine {
+	r := gin.New()
+	r.GET("/healthz", healthz)
+	r.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting", "error": "tasks are still loading"})
+	})
+	return r
+}
+
+// writableStore - хранилище, которое пишет задачи на диск
+type writableStore interface {
+	// Writable проверяет, можно ли сейчас записать файлы хранилища
+	Writable() error
+}
+
+// checkWritable проверяет, можно ли создать файл в каталоге dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writableCheckEvery - как долго /readyz верит прошлой проверке хранилища
+const writableCheckEvery = 5 * time.Second
+
+// writableResult - итог проверки хранилища
+type writableResult struct {
+	err error
+	at  time.Time
+}
+
+// writableCache - последние проверки хранилищ (writableStore.Writable)
+type writableCache struct {
+	mu      sync.Mutex
+	checked map[writableStore]writableResult
+	now     func() time.Time
+}
+
+// newWritableCache создает пустой кэш проверок
+func newWritableCache() *writableCache {
+	return &writableCache{checked: make(map[writableStore]writableResult), now: time.Now}
+}
+
+// check проверяет хранилище ws, если прошлая проверка старше
+// writableCheckEvery, и возвращает ее результат
+func (wc *writableCache) check(ws writableStore) error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	now := wc.now()
+	if r, ok := wc.checked[ws]; ok && now.Sub(r.at) < writableCheckEvery {
+		return r.err
+	}
+	// заодно забываются устаревшие проверки, в том числе удаленных проектов
+	for key, r := range wc.checked {
+		if now.Sub(r.at) >= writableCheckEvery {
+			delete(wc.checked, key)
+		}
+	}
+	err := ws.Writable()
+	wc.checked[ws] = writableResult{err: err, at: now}
+	return err
+}
+
+// обработчик запроса GET /healthz
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// обработчик запроса GET /readyz
+func (h *handlers) readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+	projects, release := h.projects.all()
+	defer release()
+	for _, ph := range projects {
+		ws, ok := ph.store.(writableStore)
+		if !ok {
+			continue
+		}
+		err := h.writable.check(ws)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "storage not writable", "project": ph.project, "error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
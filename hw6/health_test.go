@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	get := func(r http.Handler, url string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w.Code
+	}
+
+	// пока задачи загружаются - только liveness
+	starting := startingRouter()
+	for url, want := range map[string]int{"/healthz": 200, "/readyz": 503, "/all": 503} {
+		if got := get(starting, url); got != want {
+			t.Errorf("starting %s: %d, want %d", url, got, want)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "data")
+	_ = os.Mkdir(dir, 0755)
+	store, err := newFileStore(filepath.Join(dir, "tasks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, store)
+	h, r := s.h, s.r
+	if got := get(r, "/readyz"); got != http.StatusOK {
+		t.Fatalf("ready: %d", got)
+	}
+
+	clock := time.Now()
+	h.writable.now = func() time.Time { return clock }
+
+	// каталог хранилища пропал - не готов, но жив; прошлой проверке
+	// /readyz верит writableCheckEvery
+	_ = os.RemoveAll(dir)
+	if got := get(r, "/readyz"); got != http.StatusOK {
+		t.Fatalf("storage gone, cached: %d", got)
+	}
+	clock = clock.Add(writableCheckEvery)
+	if got := get(r, "/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("storage gone: %d", got)
+	}
+	if got := get(r, "/healthz"); got != http.StatusOK {
+		t.Fatalf("healthz: %d", got)
+	}
+	_ = os.Mkdir(dir, 0755)
+	h.draining.Store(true)
+	if got := get(r, "/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("draining: %d", got)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+	srv := newServer(ln.Addr().String())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	status := func(url string) int {
+		resp, err := http.Get(base + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("readyz before load: %d", got)
+	}
+	r := newTestServer(t, nil).r
+	started := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+	srv.setRouter(r)
+	if got := status("/readyz"); got != http.StatusOK {
+		t.Fatalf("readyz after load: %d", got)
+	}
+
+	// открытый поток событий и медленный запрос
+	stream, err := http.Get(base + "/events/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		slow <- string(body)
+	}()
+	<-started
+
+	// остановка дожидается медленного запроса и закрывает поток
+	err = srv.shutdown(5 * time.Second)
+	if err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if got := <-slow; got != "done" {
+		t.Fatalf("in-flight request: %q", got)
+	}
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("serve: %v", err)
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Fatal("server still accepts connections")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer - обработчики и маршруты для тестов запросов
+type testServer struct {
+	t *testing.T
+	h *handlers
+	r *gin.Engine
+}
+
+// newTestServer создает сервер на хранилище store (nil - в памяти) с ключами
+// keys: ключ пользователя - "<имя>-key", если Key не задан. Без ключей
+// аутентификация выключена, и все запросы - от "anonymous".
+func newTestServer(t *testing.T, store TaskStore, keys ...apiKey) *testServer {
+	t.Helper()
+	var cfg authConfig
+	for _, k := range keys {
+		if k.Key == "" {
+			k.Key = k.Name + "-key"
+		}
+		cfg.Keys = append(cfg.Keys, k)
+	}
+	return newTestServerAuth(t, store, cfg)
+}
+
+// newTestServerAuth - то же с полными настройками аутентификации
+// (пустые - без аутентификации)
+func newTestServerAuth(t *testing.T, store TaskStore, cfg authConfig) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if store == nil {
+		store = newMemoryStore(nil)
+	}
+	var auth *authenticator
+	if len(cfg.Keys) > 0 || len(cfg.Users) > 0 {
+		if cfg.TokenSecret == "" {
+			cfg.TokenSecret = "test secret"
+		}
+		var err error
+		auth, err = newAuthenticatorFromConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &testServer{t: t, h: newHandlers(store, nil, nil, auth)}
+	s.r = newRouter(s.h)
+	return s
+}
+
+// rebuild заново собирает маршруты (после замены s.h.limits и т.п.)
+func (s *testServer) rebuild() {
+	s.r = newRouter(s.h)
+}
+
+// request - запрос от user ("" - без учетных данных) с телом body в JSON
+// (PATCH - merge patch) и заголовками header (имя, значение, ...)
+func (s *testServer) request(user, method, url string, body any, header ...string) *http.Request {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	if user != "" {
+		req.Header.Set("X-API-Key", user+"-key")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", mergePatchType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return req
+}
+
+// serve выполняет запрос req
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.r.ServeHTTP(w, req)
+	return w
+}
+
+// do выполняет запрос (см. request)
+func (s *testServer) do(user, method, url string, body any, header ...string) *httptest.ResponseRecorder {
+	return s.serve(s.request(user, method, url, body, header...))
+}
+
+// ok выполняет запрос, который должен завершиться 200, и разбирает ответ в v (nil - не нужен)
+func (s *testServer) ok(user, method, url string, body any, v any) {
+	s.t.Helper()
+	w := s.do(user, method, url, body)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("%s %s as %q: %d %s", method, url, user, w.Code, w.Body)
+	}
+	if v != nil {
+		decodeBody(s.t, w, v)
+	}
+}
+
+// decodeBody разбирает ответ w в v
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+}
+
+func decodeTasks(t *testing.T, w *httptest.ResponseRecorder) []Task {
+	t.Helper()
+	var tasks []Task
+	decodeBody(t, w, &tasks)
+	return tasks
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// История изменений задач: каждое изменение хранилища (через TaskStore.Watch)
+// записывается как событие с изменениями полей "было/стало", временем и автором.
+// История хранится отдельно от задач (history.jsonl), поэтому не пропадает
+// при удалении задачи.
+
+// типы событий
+const (
+	eventCreated  = "created"
+	eventUpdated  = "updated"
+	eventDeleted  = "deleted" // перенесена в корзину
+	eventRestored = "restored"
+	eventPurged   = "purged" // удалена из корзины навсегда
+)
+
+var eventTypes = map[string]string{
+	opCreate:  eventCreated,
+	opUpdate:  eventUpdated,
+	opTrash:   eventDeleted,
+	opRestore: eventRestored,
+	opDelete:  eventPurged,
+}
+
+// поля, которые меняются при каждом изменении и в истории только мешают
+var historySkipFields = map[string]bool{
+	"seq": true, "version": true, "updated_at": true, "updated_by": true, "blocked": true,
+}
+
+// Event - одно изменение задачи
+type Event struct {
+	Seq     uint64        `json:"seq"`
+	Project string        `json:"project,omitempty"` // ID проекта (кроме default, см. project.go)
+	Type    string        `json:"type"`
+	TaskID  string        `json:"task_id"`
+	Actor   string        `json:"actor"`
+	Time    time.Time     `json:"time"`
+	Version uint64        `json:"version,omitempty"` // версия задачи после изменения
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange - изменение одного поля (before/after отсутствуют, если поле было/стало пустым)
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// eventLog - журнал событий в памяти с дозаписью в файл
+type eventLog struct {
+	mu     sync.RWMutex
+	events []Event
+	byTask map[string][]int // ID задачи -> индексы ее событий
+	file   *os.File         // nil - только в памяти
+	hub    *eventHub        // рассылка новых событий (stream.go)
+
+	project string // ID проекта событий ("" - default)
+}
+
+// newEventLog загружает историю из файла path ("" - история только в памяти)
+func newEventLog(path string) (*eventLog, error) {
+	l := &eventLog{byTask: make(map[string][]int)}
+	if path == "" {
+		l.hub = newEventHub(streamBuffer, 0)
+		return l, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e Event
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// испорченная строка (например, обрезанная при падении) не мешает остальным
+			log.Printf("history %s: line %d skipped: %v", path, line, err)
+			continue
+		}
+		l.add(e)
+	}
+	err = scanner.Err()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.file = f
+	l.hub = newEventHub(streamBuffer, l.lastSeq())
+	return l, nil
+}
+
+// add добавляет событие в память (вызывается под l.mu)
+func (l *eventLog) add(e Event) {
+	l.events = append(l.events, e)
+	l.byTask[e.TaskID] = append(l.byTask[e.TaskID], len(l.events)-1)
+}
+
+// lastSeq - номер последнего события (вызывается под l.mu)
+func (l *eventLog) lastSeq() uint64 {
+	if len(l.events) == 0 {
+		return 0
+	}
+	return l.events[len(l.events)-1].Seq
+}
+
+// record - taskWatcher: записывает изменение задачи как событие
+// (задачи, которые уже были в хранилище при подписке, в историю не попадают)
+func (l *eventLog) record(op string, before, after Task) {
+	if op == opLoad {
+		return
+	}
+	e := Event{
+		Project: l.project,
+		Type:    eventTypes[op],
+		TaskID:  before.ID,
+		Actor:   after.UpdatedBy,
+		Time:    time.Now(),
+		Version: after.Version,
+	}
+	task := after
+	if op == opCreate {
+		e.TaskID = after.ID
+	}
+	if op == opDelete {
+		task, after = before, Task{}
+	}
+	e.Changes = diffTasks(before, after)
+
+	l.mu.Lock()
+	e.Seq = l.lastSeq() + 1
+	l.add(e)
+	l.save(e)
+	l.mu.Unlock()
+
+	// рассылка - вне l.mu, но еще под блокировкой хранилища,
+	// поэтому события приходят подписчикам по порядку
+	l.hub.publish(streamEvent{Event: e, Task: task})
+}
+
+// save дописывает событие в файл (вызывается под l.mu)
+func (l *eventLog) save(e Event) {
+	if l.file == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = l.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("history: event %d not saved: %v", e.Seq, err)
+	}
+}
+
+// diffTasks - изменившиеся поля задачи (по именам JSON, по алфавиту)
+func diffTasks(before, after Task) []FieldChange {
+	a, err := taskDocument(before)
+	if err != nil {
+		return nil
+	}
+	b, err := taskDocument(after)
+	if err != nil {
+		return nil
+	}
+	var changes []FieldChange
+	for field, old := range a {
+		if historySkipFields[field] || jsonEqual(old, b[field]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: emptyToNil(old), After: emptyToNil(b[field])})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// emptyToNil убирает пустые значения ("", 0, [], null), чтобы они не попадали в JSON
+func emptyToNil(v any) any {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case json.Number:
+		if v == "0" {
+			return nil
+		}
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+	}
+	return v
+}
+
+// forTask возвращает события задачи по порядку
+func (l *eventLog) forTask(id string) []Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	events := make([]Event, 0, len(l.byTask[id]))
+	for _, i := range l.byTask[id] {
+		events = append(events, l.events[i])
+	}
+	return events
+}
+
+// since возвращает до limit событий с номером больше seq и временем не раньше t
+func (l *eventLog) since(seq uint64, t time.Time, limit int) []Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// номера событий растут, поэтому начало ищем двоичным поиском
+	i := sort.Search(len(l.events), func(i int) bool { return l.events[i].Seq > seq })
+	events := make([]Event, 0)
+	for ; i < len(l.events) && len(events) < limit; i++ {
+		if !l.events[i].Time.Before(t) {
+			events = append(events, l.events[i])
+		}
+	}
+	return events
+}
+
+// Close закрывает файл истории
+func (l *eventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// обработчик запроса GET /task/:id/history - история задачи (в том числе удаленной)
+func (h *handlers) taskHistory(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canSeeID(principalFrom(c), id) {
+		storeError(c, errTaskNotFound)
+		return
+	}
+	events := h.events.forTask(id)
+	if len(events) == 0 {
+		if _, err := h.store.Get(id); err != nil {
+			storeError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": id, "events": events})
+}
+
+// обработчик запроса GET /events?since=  &limit=
+// since - номер последнего полученного события или время RFC 3339
+func (h *handlers) listEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	limit = min(limit, 1000)
+
+	var seq uint64
+	var from time.Time
+	if since := c.Query("since"); since != "" {
+		seq, err = strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			from, err = time.Parse(time.RFC3339, since)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an event number or RFC 3339 time"})
+				return
+			}
+		}
+	}
+
+	events := h.events.since(seq, from, limit)
+	next := seq
+	if len(events) > 0 {
+		next = events[len(events)-1].Seq
+	}
+	// события чужих задач пропускаем (next_since - после всех прочитанных)
+	p := principalFrom(c)
+	if !p.Admin {
+		visible := make(map[string]bool)
+		mine := events[:0]
+		for _, e := range events {
+			ok, seen := visible[e.TaskID]
+			if !seen {
+				ok = h.canSeeID(p, e.TaskID)
+				visible[e.TaskID] = ok
+			}
+			if ok {
+				mine = append(mine, e)
+			}
+		}
+		events = mine
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "next_since": next})
+}
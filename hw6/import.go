@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Загрузка задач: POST /import?format=csv|ndjson|yaml|md (см. export.go)
+//
+//	&map=Название:title,Заметки:description,Лишнее:-  - соответствие колонок csv/md полям задачи
+//	&delimiter=;                                      - разделитель csv (по умолчанию ",")
+//	&dry_run=true                                     - только проверить, ничего не меняя
+//
+// Задача без id создается, задача с id существующей задачи заменяет ее
+// (как PUT), задача с новым id создается с этим id - так загрузка выгрузки
+// сохраняет ID и зависимости. Каждая задача проверяется по тем же правилам,
+// что и в POST /task; если хоть одна не прошла проверку, не загружается
+// ничего, а в ответе перечислены ошибки всех строк (400; если превышена
+// квота - 403 quota_exceeded, как в POST /task). Все задачи загружаются
+// одной записью на диск (TaskStore.Batch).
+
+// maxImportSize - максимальный размер загружаемого файла
+var maxImportSize int64 = 10 << 20
+
+// форматы загрузки по Content-Type (если нет ?format=)
+var importTypes = map[string]string{
+	"text/csv":             "csv",
+	"application/x-ndjson": "ndjson",
+	"application/yaml":     "yaml",
+	"application/x-yaml":   "yaml",
+	"text/yaml":            "yaml",
+	"text/markdown":        "md",
+}
+
+// importFields - поля задачи, которые можно загрузить из колонок csv и md
+var importFields = []string{"id", "title", "description", "status", "priority", "due_at", "blocked_by", "owner", "shared_with"}
+
+// importRow - задача из файла: документ JSON (или ошибка его разбора) и строка файла
+type importRow struct {
+	Line int
+	Doc  map[string]any
+	Err  error
+}
+
+// importError - ошибка задачи Row (номер в файле, с 1) в строке файла Line
+type importError struct {
+	Row   int    `json:"row"`
+	Line  int    `json:"line,omitempty"`
+	Error string `json:"error"`
+	err   error  // исходная ошибка (превышение квоты - 403, см. importTasks)
+}
+
+// importReport - ответ POST /import
+type importReport struct {
+	DryRun  bool          `json:"dry_run"`
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Errors  []importError `json:"errors"`
+}
+
+// serverField - поле, которое задает сервер (при загрузке пропускается)
+func serverField(name string) bool {
+	return name != "id" && (slices.Contains(immutableFields, name) || slices.Contains(derivedFields, name))
+}
+
+// readImport разбирает файл в формате format на задачи
+func readImport(r io.Reader, format string, mapping map[string]string, comma rune) ([]importRow, error) {
+	switch format {
+	case "csv":
+		return readCSV(r, mapping, comma)
+	case "ndjson":
+		return readNDJSON(r)
+	case "yaml":
+		return readYAML(r)
+	case "md":
+		return readMarkdown(r, mapping)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func readCSV(r io.Reader, mapping map[string]string, comma rune) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := mapColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		// лишние или недостающие колонки - ошибка строки, а не всего файла
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		row := importRow{Line: line, Err: err}
+		if err == nil {
+			row.Doc, row.Err = cellsDocument(fields, record)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readNDJSON(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(maxImportSize))
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		row := importRow{Line: line}
+		v, err := decodeJSON(data)
+		row.Doc, row.Err = jsonObject(v, err)
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+func readYAML(r io.Reader) ([]importRow, error) {
+	var doc yaml.Node
+	err := yaml.NewDecoder(r).Decode(&doc)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, errors.New("yaml must be a list of tasks")
+	}
+	rows := make([]importRow, 0, len(list.Content))
+	for _, item := range list.Content {
+		row := importRow{Line: item.Line}
+		var v any
+		err = item.Decode(&v)
+		if err == nil {
+			// через JSON, чтобы значения были теми же типами, что и в запросах
+			var data []byte
+			data, err = json.Marshal(v)
+			if err == nil {
+				v, err = decodeJSON(data)
+			}
+		}
+		row.Doc, row.Err = jsonObject(v, err)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// jsonObject проверяет, что значение v (с ошибкой разбора err) - объект JSON
+func jsonObject(v any, err error) (map[string]any, error) {
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("task must be an object")
+	}
+	return m, nil
+}
+
+// readMarkdown читает первую таблицу Markdown (текст вокруг нее пропускается)
+func readMarkdown(r io.Reader, mapping map[string]string) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(maxImportSize))
+	var fields []string
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "|") {
+			if fields != nil {
+				break // таблица закончилась
+			}
+			continue
+		}
+		cells := mdSplit(text)
+		if fields == nil {
+			var err error
+			fields, err = mapColumns(cells, mapping)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			continue
+		}
+		if strings.Trim(text, "|-: ") == "" {
+			continue // строка-разделитель под заголовком
+		}
+		row := importRow{Line: line}
+		if len(cells) != len(fields) {
+			row.Err = fmt.Errorf("got %d cells, want %d", len(cells), len(fields))
+		} else {
+			row.Doc, row.Err = cellsDocument(fields, cells)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// mdSplit разбивает строку таблицы Markdown на ячейки (см. mdEscape)
+func mdSplit(line string) []string {
+	line = strings.TrimPrefix(line, "|")
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && (line[i+1] == '|' || line[i+1] == '\\'):
+			i++
+			cell.WriteByte(line[i])
+		case line[i] == '|':
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	// последняя ячейка без закрывающего "|"
+	if s := strings.TrimSpace(cell.String()); s != "" {
+		cells = append(cells, s)
+	}
+	br := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n")
+	for i := range cells {
+		cells[i] = br.Replace(strings.TrimSpace(cells[i]))
+	}
+	return cells
+}
+
+// parseColumnMap разбирает ?map=колонка:поле,колонка:поле
+func parseColumnMap(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if s == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		column, field, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("map: want column:field, got %q", pair)
+		}
+		mapping[strings.TrimSpace(column)] = strings.TrimSpace(field)
+	}
+	return mapping, nil
+}
+
+// mapColumns сопоставляет колонки заголовка полям задачи
+// ("" - колонка пропускается: "-" в map или поле, которое задает сервер)
+func mapColumns(header []string, mapping map[string]string) ([]string, error) {
+	fields := make([]string, len(header))
+	found := make(map[string]bool)
+	seen := make(map[string]bool)
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		field := column
+		if m, ok := mapping[column]; ok {
+			field = m
+			found[column] = true
+		}
+		switch {
+		case field == "-" || serverField(field):
+			continue
+		case !slices.Contains(importFields, field):
+			return nil, fmt.Errorf("unknown column %q (map it to a task field or to -)", column)
+		case seen[field]:
+			return nil, fmt.Errorf("field %q mapped twice", field)
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+	for column := range mapping {
+		if !found[column] {
+			return nil, fmt.Errorf("map: no column %q", column)
+		}
+	}
+	if !seen["title"] {
+		return nil, errors.New("no title column")
+	}
+	return fields, nil
+}
+
+// cellsDocument собирает документ задачи из ячеек строки csv/md
+func cellsDocument(fields, cells []string) (map[string]any, error) {
+	doc := make(map[string]any)
+	for i, field := range fields {
+		if field == "" {
+			continue
+		}
+		v, err := cellValue(field, strings.TrimSpace(cells[i]))
+		if err != nil {
+			return nil, err
+		}
+		doc[field] = v
+	}
+	return doc, nil
+}
+
+// cellValue переводит текст ячейки в значение поля (пустая ячейка - пустое поле)
+func cellValue(field, s string) (any, error) {
+	switch field {
+	case "status":
+		if s == "" {
+			return false, nil
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("status: %q is not true or false", s)
+		}
+		return v, nil
+	case "priority":
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("priority: %q is not a number from 0 to 255", s)
+		}
+		return v, nil
+	case "due_at":
+		if s == "" {
+			return nil, nil
+		}
+	case "blocked_by", "shared_with":
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' || r == ';' }), nil
+	}
+	return s, nil
+}
+
+// rowTask превращает документ в задачу по тем же правилам, что и POST /task
+// (binding), но неизвестные поля запрещены
+func rowTask(doc map[string]any) (Task, error) {
+	for field := range doc {
+		if serverField(field) {
+			delete(doc, field)
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Task{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var task Task
+	err = dec.Decode(&task)
+	if err != nil {
+		return Task{}, err
+	}
+	err = binding.Validator.ValidateStruct(&task)
+	if err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// planImport проверяет задачи файла и готовит изменения хранилища
+// от имени p (вызывается под h.mu)
+func (h *handlers) planImport(rows []importRow, p Principal) ([]change, importReport) {
+	report := importReport{Rows: len(rows), Errors: []importError{}}
+	fail := func(i int, err error) {
+		report.Errors = append(report.Errors, importError{Row: i + 1, Line: rows[i].Line, Error: err.Error(), err: err})
+	}
+
+	stored := taskMap(h.store.List())
+	trash := taskMap(h.store.Trash())
+	tasks := taskMap(h.store.List()) // задачи после загрузки
+	ops := make([]change, len(rows))
+	valid := make([]bool, len(rows))
+	seen := make(map[string]int)
+	for i, row := range rows {
+		if row.Err != nil {
+			fail(i, row.Err)
+			continue
+		}
+		task, err := rowTask(row.Doc)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		op := opCreate
+		current, ok := stored[task.ID]
+		if ok {
+			op = opUpdate
+			task.Version = current.Version
+		}
+		// чужая задача для p не существует, и ее ID занят
+		if ok && !p.canSee(current) {
+			fail(i, fmt.Errorf("%w: %s", errTaskExists, task.ID))
+			continue
+		}
+		switch _, dup := seen[task.ID]; {
+		case task.ID == "":
+			task.ID = uuid.NewString()
+		case dup:
+			fail(i, fmt.Errorf("duplicate id %s (row %d)", task.ID, seen[task.ID]+1))
+			continue
+		case trash[task.ID].ID != "":
+			fail(i, fmt.Errorf("task %s is in the trash", task.ID))
+			continue
+		case op == opCreate && uuid.Validate(task.ID) != nil:
+			fail(i, fmt.Errorf("id %q is not a UUID", task.ID))
+			continue
+		}
+		task.UpdatedBy = p.Name
+		seen[task.ID] = i
+		tasks[task.ID] = task
+		ops[i] = change{Op: op, Task: task}
+		valid[i] = true
+	}
+
+	// зависимости проверяем, когда известны все задачи файла:
+	// задача может ссылаться на задачи из следующих строк
+	quota := h.taskQuota()
+	for i, ch := range ops {
+		if !valid[i] {
+			continue
+		}
+		id := ch.Task.ID
+		if current, ok := stored[id]; ok {
+			tasks[id] = current
+		} else {
+			delete(tasks, id)
+		}
+		err := authorizeWrite(p, &ch.Task, stored[id], tasks)
+		if err == nil {
+			err = checkDependencies(ch.Task, tasks)
+		}
+		if err == nil && ch.Op == opCreate {
+			err = quota.add(ch.Task.Owner)
+		}
+		ops[i].Task = ch.Task
+		tasks[id] = ch.Task
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		if ch.Op == opCreate {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	return ops, report
+}
+
+// обработчик запроса POST /import?format=  &map=  &delimiter=  &dry_run=
+func (h *handlers) importTasks(c *gin.Context) {
+	format := c.Query("format")
+	if format == "" {
+		format = importTypes[c.ContentType()]
+	}
+	if _, ok := exportTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ndjson, yaml or md"})
+		return
+	}
+	mapping, err := parseColumnMap(c.Query("map"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	comma := ','
+	if d := c.Query("delimiter"); d != "" {
+		if utf8.RuneCountInString(d) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delimiter must be one character"})
+			return
+		}
+		comma, _ = utf8.DecodeRuneInString(d)
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	rows, err := readImport(body, format, mapping, comma)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no tasks to import"})
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ops, report := h.planImport(rows, principalFrom(c))
+	report.DryRun = dryRun
+	logAttrs(c, "format", format, "rows", report.Rows, "dry_run", dryRun)
+	// квота - как в POST /task и POST /tasks/batch: 403 quota_exceeded
+	// (вместе с ошибками всех строк)
+	if i := slices.IndexFunc(report.Errors, func(e importError) bool { return errors.Is(e.err, errQuota) }); i >= 0 {
+		quotaExceeded(c, report.Errors[i].err, gin.H{
+			"dry_run": report.DryRun, "rows": report.Rows, "created": report.Created, "updated": report.Updated, "errors": report.Errors,
+		})
+		return
+	}
+	if len(report.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, report)
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	_, err = h.store.Batch(ops)
+	var berr *batchError
+	if errors.As(err, &berr) {
+		report.Created, report.Updated = 0, 0
+		report.Errors = append(report.Errors, importError{Row: berr.Index + 1, Line: rows[berr.Index].Line, Error: berr.Err.Error()})
+		c.JSON(storeStatus(berr.Err), report)
+		return
+	}
+	if err != nil && !errors.Is(err, errNotSaved) {
+		storeError(c, err)
+		return
+	}
+	// задачи загружены, но файл не записан
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"dry_run": false, "rows": report.Rows, "created": report.Created, "updated": report.Updated, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
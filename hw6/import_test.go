@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// importFile загружает файл data от имени user (POST /import?query)
+func (s *testServer) importFile(user, query, data string) (*httptest.ResponseRecorder, importReport) {
+	s.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/import?"+query, strings.NewReader(data))
+	if user != "" {
+		req.Header.Set("X-API-Key", user+"-key")
+	}
+	w := s.serve(req)
+	var report importReport
+	if w.Code == http.StatusOK || w.Code == http.StatusBadRequest && strings.Contains(w.Body.String(), `"errors"`) {
+		decodeBody(s.t, w, &report)
+	}
+	return w, report
+}
+
+// sameTasks сравнивает загружаемые поля задач (без полей, которые задает сервер)
+func sameTasks(a, b Task) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Description == b.Description &&
+		a.Status == b.Status && a.Priority == b.Priority && a.Owner == b.Owner &&
+		(a.DueAt == nil) == (b.DueAt == nil) && (a.DueAt == nil || a.DueAt.Equal(*b.DueAt)) &&
+		slices.Equal(a.BlockedBy, b.BlockedBy) && slices.Equal(a.SharedWith, b.SharedWith)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newTestServer(t, nil)
+	due := time.Date(2026, 5, 1, 9, 30, 0, 0, time.FixedZone("", 3*3600))
+	for _, task := range []Task{
+		{Title: "Первая", Description: "строка 1\nстрока 2, с запятой | и чертой \\ слеш", Priority: 3, DueAt: &due},
+		{Title: `"Кавычки"`, Status: true},
+		{Title: "Третья"},
+	} {
+		src.ok("", http.MethodPost, "/task", task, nil)
+	}
+	tasks := decodeTasks(t, src.do("", http.MethodGet, "/all", nil))
+	// первая задача ссылается на следующую строку файла
+	src.ok("", http.MethodPatch, "/task/"+tasks[0].ID, map[string]any{"blocked_by": []string{tasks[2].ID}}, nil)
+	tasks = decodeTasks(t, src.do("", http.MethodGet, "/all", nil))
+
+	for format := range exportTypes {
+		w := src.do("", http.MethodGet, "/export?format="+format, nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != exportTypes[format] {
+			t.Fatalf("%s: export %d %s", format, w.Code, w.Header().Get("Content-Type"))
+		}
+		data := w.Body.String()
+
+		// в пустое хранилище: задачи создаются с теми же ID
+		dst := newTestServer(t, nil)
+		w, report := dst.importFile("", "format="+format, data)
+		if w.Code != http.StatusOK || report.Rows != 3 || report.Created != 3 || report.Updated != 0 {
+			t.Fatalf("%s: import %d %s", format, w.Code, w.Body)
+		}
+		got := decodeTasks(t, dst.do("", http.MethodGet, "/all", nil))
+		if len(got) != len(tasks) {
+			t.Fatalf("%s: %d tasks", format, len(got))
+		}
+		for i := range got {
+			if !sameTasks(got[i], tasks[i]) {
+				t.Errorf("%s: task %d\n got %+v\nwant %+v", format, i, got[i], tasks[i])
+			}
+		}
+
+		// обратно в исходное: те же задачи заменяются
+		w, report = src.importFile("", "format="+format, data)
+		if w.Code != http.StatusOK || report.Created != 0 || report.Updated != 3 {
+			t.Fatalf("%s: reimport %d %s", format, w.Code, w.Body)
+		}
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "была"}, nil)
+	old := decodeTasks(t, s.do("", http.MethodGet, "/all", nil))[0]
+
+	data := "title,priority,id\nновая,1,\nзамена,2," + old.ID + "\n"
+	w, report := s.importFile("", "format=csv&dry_run=true", data)
+	if w.Code != http.StatusOK || !report.DryRun || report.Created != 1 || report.Updated != 1 {
+		t.Fatalf("dry run: %d %s", w.Code, w.Body)
+	}
+	if tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil)); len(tasks) != 1 || tasks[0].Title != "была" || tasks[0].Version != old.Version {
+		t.Fatalf("dry run changed tasks: %+v", tasks)
+	}
+
+	w, report = s.importFile("", "format=csv", data)
+	if w.Code != http.StatusOK || report.DryRun || report.Created != 1 || report.Updated != 1 {
+		t.Fatalf("import: %d %s", w.Code, w.Body)
+	}
+	if tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil)); len(tasks) != 2 || tasks[0].Title != "замена" || tasks[0].Priority != 2 {
+		t.Fatalf("after import: %+v", tasks)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	s := newTestServer(t, nil, apiKey{Name: "alice"}, apiKey{Name: "bob"})
+	s.ok("bob", http.MethodPost, "/task", Task{Title: "чужая"}, nil)
+	s.ok("alice", http.MethodPost, "/task", Task{Title: "в корзине"}, nil)
+	bobs := decodeTasks(t, s.do("bob", http.MethodGet, "/all", nil))[0]
+	trashed := decodeTasks(t, s.do("alice", http.MethodGet, "/all", nil))[0]
+	s.ok("alice", http.MethodDelete, "/tasks/"+trashed.ID, nil, nil)
+	free := uuid.NewString()
+
+	// ошибки всех строк сразу, с номерами задач и строк файла
+	data := strings.Join([]string{
+		`{"title": "ok"}`,
+		`{"title": "ok", "id": "` + free + `"}`,
+		``,
+		`{"title": `,
+		`[1, 2]`,
+		`{"description": "без заголовка"}`,
+		`{"title": "x", "colour": "red"}`,
+		`{"title": "x", "id": "` + free + `"}`,
+		`{"title": "x", "id": "task-1"}`,
+		`{"title": "x", "id": "` + bobs.ID + `"}`,
+		`{"title": "x", "id": "` + trashed.ID + `"}`,
+		`{"title": "x", "blocked_by": ["` + uuid.NewString() + `"]}`,
+		`{"title": "x", "status": true, "blocked_by": ["` + free + `"]}`,
+	}, "\n")
+	w, report := s.importFile("alice", "format=ndjson", data)
+	want := []struct {
+		row, line int
+		msg       string
+	}{
+		{3, 4, "unexpected EOF"},
+		{4, 5, "must be an object"},
+		{5, 6, "Title"},
+		{6, 7, "colour"},
+		{7, 8, "duplicate id " + free + " (row 2)"},
+		{8, 9, "not a UUID"},
+		{9, 10, errTaskExists.Error()},
+		{10, 11, "in the trash"},
+		{11, 12, "not found"},
+		{12, 13, errTaskBlocked.Error()},
+	}
+	if w.Code != http.StatusBadRequest || report.Rows != 12 || len(report.Errors) != len(want) {
+		t.Fatalf("import: %d %s", w.Code, w.Body)
+	}
+	for i, e := range report.Errors {
+		if e.Row != want[i].row || e.Line != want[i].line || !strings.Contains(e.Error, want[i].msg) {
+			t.Errorf("error %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+	// не загружено ничего, в том числе верные строки
+	if tasks := decodeTasks(t, s.do("alice", http.MethodGet, "/all", nil)); len(tasks) != 0 {
+		t.Fatalf("imported: %+v", tasks)
+	}
+
+	// csv: номер строки файла учитывает заголовок
+	w, report = s.importFile("alice", "format=csv&map=Название:title,Лишнее:-", "Название,Лишнее\nok,1\n,2\n")
+	if w.Code != http.StatusBadRequest || len(report.Errors) != 1 || report.Errors[0].Row != 2 || report.Errors[0].Line != 3 {
+		t.Fatalf("csv: %d %s", w.Code, w.Body)
+	}
+
+	for _, query := range []string{"format=xml", "format=csv&delimiter=%3B%3B", "format=csv&dry_run=maybe", "format=csv&map=title"} {
+		if w, _ := s.importFile("alice", query, "title\nx\n"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", query, w.Code, w.Body)
+		}
+	}
+	if w, _ := s.importFile("alice", "format=ndjson", "\n\n"); w.Code != http.StatusBadRequest {
+		t.Errorf("empty file: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Журнал запросов (log/slog).
+//
+// На каждый запрос - одна запись "request": request_id, method, route
+// (шаблон маршрута gin), path, status, latency_ms, bytes, client_ip,
+// principal, project и поля, которые добавил обработчик (logAttrs).
+// Ошибки, переданные через c.Error (например, задача изменена, но файл
+// не записан), попадают в поле error. Уровень записи: ошибка сервера
+// или c.Error - ERROR, 4xx - WARN, успешные проверки /healthz и /readyz -
+// DEBUG, остальное - INFO.
+//
+// X-Request-ID берется из запроса (если он похож на идентификатор) или
+// создается заново и возвращается в ответе; он же - в записях, которые
+// обработчик пишет через loggerFrom. Фоновые сообщения (log.Printf)
+// после slog.SetDefault идут в тот же журнал.
+
+// requestIDPattern - допустимый X-Request-ID клиента (остальные заменяются,
+// чтобы в журнал не попало что попало)
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// probeRoutes - проверки оркестратора (health.go): успешные пишутся с DEBUG
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// requestLogKey - ключ requestLog в gin.Context
+const requestLogKey = "request_log"
+
+// requestLog - журнал запроса и поля его записи
+type requestLog struct {
+	logger *slog.Logger
+	attrs  []any
+}
+
+// newLogger создает журнал в формате format ("json" или "text") с уровнем
+// level (slog.LevelVar - чтобы менять уровень на ходу, см. config.go)
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q (want json or text)", format)
+	}
+}
+
+// parseLevel разбирает уровень журнала ("debug", "info", "warn", "error")
+func parseLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return lvl, fmt.Errorf("log level %q: %w", level, err)
+	}
+	return lvl, nil
+}
+
+// logRequests - middleware, которая пишет запись о каждом запросе
+func (h *handlers) logRequests(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader("X-Request-ID")
+	if !requestIDPattern.MatchString(id) {
+		id = uuid.NewString()
+	}
+	c.Header("X-Request-ID", id)
+	rl := &requestLog{logger: h.logger.With("request_id", id)}
+	c.Set(requestLogKey, rl)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError || len(c.Errors) > 0:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	case probeRoutes[c.FullPath()]:
+		level = slog.LevelDebug // оркестратор спрашивает каждые несколько секунд
+	}
+	attrs := []any{
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", c.Request.URL.Path,
+		"status", status,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"bytes", max(c.Writer.Size(), 0),
+		"client_ip", c.ClientIP(),
+	}
+	if p := principalFrom(c); p.Name != "" {
+		attrs = append(attrs, "principal", p.Name)
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, "error", strings.Join(c.Errors.Errors(), "; "))
+	}
+	attrs = append(attrs, rl.attrs...)
+	rl.logger.Log(c.Request.Context(), level, "request", attrs...)
+}
+
+// logAttrs добавляет поля в запись о запросе (пары ключ-значение, как в slog)
+func logAttrs(c *gin.Context, args ...any) {
+	if v, ok := c.Get(requestLogKey); ok {
+		rl := v.(*requestLog)
+		rl.attrs = append(rl.attrs, args...)
+	}
+}
+
+// loggerFrom - журнал с request_id запроса c
+func loggerFrom(c *gin.Context) *slog.Logger {
+	if v, ok := c.Get(requestLogKey); ok {
+		return v.(*requestLog).logger
+	}
+	return slog.Default()
+}
+
+// recoverPanic отвечает 500 на панику в обработчике; сама паника
+// попадает в запись о запросе
+func recoverPanic(c *gin.Context, err any) {
+	loggerFrom(c).Error("panic", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+	_ = c.Error(fmt.Errorf("panic: %v", err))
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+}
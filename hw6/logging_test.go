@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestLog(t *testing.T) {
+	// каталог файла удален: задачи меняются, но не записываются
+	dir := filepath.Join(t.TempDir(), "gone")
+	_ = os.Mkdir(dir, 0755)
+	store, err := newFileStore(filepath.Join(dir, "tasks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.RemoveAll(dir)
+	s := newTestServer(t, store)
+	var out bytes.Buffer
+	s.h.logger, err = newLogger(&out, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, url string, body any, header ...string) (*httptest.ResponseRecorder, map[string]any) {
+		out.Reset()
+		w := s.do("", method, url, body, header...)
+		var entry map[string]any
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("%s %s: log is not one JSON line: %q", method, url, out.String())
+		}
+		return w, entry
+	}
+
+	// X-Request-ID клиента возвращается и попадает в журнал
+	w, entry := do(http.MethodGet, "/all", nil, "X-Request-ID", "req-42")
+	if w.Header().Get("X-Request-ID") != "req-42" || entry["request_id"] != "req-42" || entry["msg"] != "request" ||
+		entry["level"] != "INFO" || entry["route"] != "/all" || entry["status"] != float64(200) || entry["principal"] != "anonymous" {
+		t.Fatalf("entry = %v", entry)
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Fatalf("no latency: %v", entry)
+	}
+
+	// без него (или с мусором) - новый
+	for _, id := range []string{"", "bad id\n{}"} {
+		w, entry := do(http.MethodGet, "/task/missing", nil, "X-Request-ID", id)
+		got := w.Header().Get("X-Request-ID")
+		if uuid.Validate(got) != nil || entry["request_id"] != got || entry["level"] != "WARN" || entry["route"] != "/task/:id" {
+			t.Fatalf("id %q: header %q, entry %v", id, got, entry)
+		}
+	}
+
+	// файл не записан: 207 и запись ERROR с причиной и полями обработчика
+	w, entry = do(http.MethodPost, "/task", Task{Title: "a"})
+	errText, _ := entry["error"].(string)
+	if w.Code != http.StatusMultiStatus || entry["level"] != "ERROR" || !strings.Contains(errText, errNotSaved.Error()) ||
+		entry["task_id"] == nil || entry["project"] != defaultProject {
+		t.Fatalf("not saved: %d %v", w.Code, entry)
+	}
+
+	// уровень и формат настраиваются
+	s.h.logger, _ = newLogger(&out, "text", slog.LevelWarn)
+	out.Reset()
+	s.do("", http.MethodGet, "/all", nil)
+	if out.Len() != 0 {
+		t.Fatalf("info entry at warn level: %q", out.String())
+	}
+	s.do("", http.MethodGet, "/task/missing", nil)
+	if !strings.Contains(out.String(), "level=WARN msg=request") {
+		t.Fatalf("text entry = %q", out.String())
+	}
+	if _, err := newLogger(&out, "xml", slog.LevelInfo); err == nil {
+		t.Fatal("log format xml accepted")
+	}
+	if _, err := parseLevel("loud"); err == nil {
+		t.Fatal("log level loud accepted")
+	}
+}
@@ -1,12 +1,22 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"io"
+	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Task struct {
@@ -15,21 +25,103 @@ type Task struct {
 	Description string `json:"description,omitempty"`
 	Status      bool   `json:"status"`
 	Priority    uint8  `json:"priority,omitempty"`
+	Seq         uint64 `json:"seq,omitempty"` // порядковый номер (задает хранилище), для курсоров пагинации
+	Version     uint64 `json:"version"`       // версия (задает хранилище), для ETag и If-Match
+
+	// срок выполнения задает клиент (RFC 3339 с часовым поясом),
+	// остальное время - сервер; в старых файлах этих полей нет
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"` // автор последнего изменения (задает сервер)
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // время переноса в корзину (см. trash.go)
+
+	// владелец (задает сервер) и с кем еще задача разделена: имена
+	// пользователей и "group:имя" (см. access.go)
+	Owner      string   `json:"owner,omitempty"`
+	SharedWith []string `json:"shared_with,omitempty"`
+
+	// зависимости (см. deps.go): ID задач, которые нужно выполнить раньше;
+	// Blocked вычисляется при ответе и от клиента не принимается
+	BlockedBy []string `json:"blocked_by,omitempty"`
+	Blocked   bool     `json:"blocked"`
 }
 
-var tasks []Task         // срез структур Task
-var index map[string]int // [ID] = индекс структуры в срезе
-var tasksPerPage = 5     // число задач на страницу для пагинации
+// handlers - обработчики запросов; хранилище задач передается через конструктор
+type handlers struct {
+	store  TaskStore
+	epoch  int64        // время запуска, входит в ETag списков задач
+	search *searchIndex // полнотекстовый индекс, обновляется через store.Watch
+	events *eventLog    // история изменений, обновляется через store.Watch
+	hooks  *webhookDispatcher
+	auth   *authenticator // nil - аутентификация выключена (см. auth.go)
+	limits *rateLimiter   // nil - без ограничения частоты запросов (см. rate.go)
+	logger *slog.Logger   // журнал запросов (см. logging.go)
+
+	draining atomic.Bool    // сервер останавливается: /readyz отвечает 503 (см. health.go)
+	writable *writableCache // результаты проверки каталогов хранилищ для /readyz
+
+	project  string      // ID проекта (см. project.go)
+	projects *projectSet // все проекты сервера
+	// настройки проекта (см. settings)
+	projectSettings atomic.Pointer[ProjectSettings]
+
+	// mu сериализует изменения задач: проверка зависимостей (deps.go)
+	// смотрит на все задачи, и до записи они не должны измениться
+	mu sync.Mutex
+}
+
+// newHandlers создает обработчики, работающие с хранилищем store;
+// изменения записываются в историю events и рассылаются вебхуками hooks
+// (nil - история и подписки только в памяти); запросы проверяет auth
+// (nil - без аутентификации, все запросы от "anonymous")
+func newHandlers(store TaskStore, events *eventLog, hooks *webhookDispatcher, auth *authenticator) *handlers {
+	if events == nil {
+		events, _ = newEventLog("")
+	}
+	if hooks == nil {
+		hooks, _ = newWebhookDispatcher("")
+	}
+	h := &handlers{store: store, epoch: time.Now().UnixNano(), search: newSearchIndex(), events: events, hooks: hooks, auth: auth, logger: slog.Default(), project: defaultProject,
+		writable: newWritableCache()}
+	store.Watch(h.search.update)
+	store.Watch(events.record)
+	events.hub.listen(hooks.enqueue)
+	// проект default; newProjectSet заменяет этот список проектами из файла
+	defaultProjectSet(h)
+	return h
+}
 
-func createIndex() {
-	index = make(map[string]int)
-	for i, task := range tasks {
-		index[task.ID] = i
+// storeError отправляет клиенту ответ на ошибку хранилища
+func storeError(c *gin.Context, err error) {
+	status := storeStatus(err)
+	if status >= http.StatusInternalServerError || status == http.StatusMultiStatus {
+		_ = c.Error(err)
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// storeStatus - код ответа на ошибку хранилища
+func storeStatus(err error) int {
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errVersionMismatch):
+		// задачу одновременно изменил другой запрос
+		return http.StatusConflict
+	case errors.Is(err, errTaskExists):
+		return http.StatusConflict
+	case errors.Is(err, errNotSaved):
+		// изменение выполнено, но файл не записан
+		return http.StatusMultiStatus
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
 // обработчик запроса POST /task
-func createTask(c *gin.Context) {
+func (h *handlers) createTask(c *gin.Context) {
 	// создаем новую задачу
 	var task Task
 
@@ -49,16 +141,45 @@ func createTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// генерируем строковый ID (UUID v.4)
-	task.ID = uuid.NewString()
 
-	// записываем задачу в срез задач
-	tasks = append(tasks, task)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	task.Blocked = false
+	task.UpdatedBy = actorFrom(c)
+	tasks := taskMap(h.store.List())
+	err = authorizeWrite(principalFrom(c), &task, Task{}, tasks)
+	if err != nil {
+		accessError(c, err)
+		return
+	}
+	err = checkDependencies(task, tasks)
+	if err != nil {
+		dependencyError(c, err)
+		return
+	}
+	err = h.taskQuota().add(task.Owner)
+	if err != nil {
+		quotaExceeded(c, err, nil)
+		return
+	}
 
-	// обновляем индекс
-	createIndex()
+	// записываем задачу в хранилище (ID генерирует хранилище)
+	task, err = h.store.Create(task)
+	if err != nil && !errors.Is(err, errNotSaved) {
+		storeError(c, err)
+		return
+	}
+	logAttrs(c, "task_id", task.ID)
+	// задача создана, но файл не записан
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"message": "задача создана с номером: " + task.ID, "error": err.Error()})
+		return
+	}
 
 	// отправляем сообщение клиенту
+	c.Header("ETag", taskETag(task))
 	// func (c *Context) JSON(code int, obj any)
 	// JSON serializes the given struct as JSON into the response body.
 	// It also sets the Content-Type as "application/json".
@@ -66,195 +187,527 @@ func createTask(c *gin.Context) {
 	// type H map[string]any
 	// H is a shortcut for map[string]any
 	c.JSON(http.StatusOK, gin.H{"message": "задача создана с номером: " + task.ID})
+}
+
+// обработчик запроса GET /all?filter=  &status=  &priority=  &due=  &due_within=  &tz=
+// (status и priority - сокращения для filter, см. filter.go; сроки - см. due.go)
+func (h *handlers) getAllTasks(c *gin.Context) {
+	// разбираем фильтр из деталей запроса
+	filter, err := filterFromQuery(c.Request.URL.Query(), time.Now())
+	if err != nil {
+		filterQueryError(c, err)
+		return
+	}
+	// Список меняется при каждом POST/PUT/DELETE, поэтому вместо
+	// max-age клиент перепроверяет его по ETag (ревизии хранилища).
+	// Ревизия читается до списка: если задачи изменятся между вызовами,
+	// ETag окажется старше данных и клиент просто получит их еще раз.
+	// (ответ на запрос по сроку зависит еще и от времени - его не кэшируем)
+	p := principalFrom(c)
+	query := c.Request.URL.Query()
+	if query.Has("due") || query.Has("due_within") {
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", "no-cache")
+		if notModified(c, collectionETag(h.epoch, h.store.Revision(), p.scope())) {
+			return
+		}
+	}
+	// только задачи, которые видит автор запроса (см. access.go)
+	tasks := h.visibleTasks(p)
+
+	markBlocked(tasks, h.blockers(tasks))
+
+	// если фильтра нет, то возвращаем все записи tasks
+	if filter == nil {
+		c.JSON(http.StatusOK, tasks)
+		return
+	}
+	// создаем временный срез для возврата отфильтрованных задач (пустой с макс. текущим объемом)
+	filterTasks := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		if filter.eval(task) {
+			filterTasks = append(filterTasks, task)
+		}
+	}
+	c.JSON(http.StatusOK, filterTasks)
+}
 
-	// записываем файл
-	err = saveTasksToFile(tasks)
+// обработчик запроса GET /task/:id
+func (h *handlers) getTask(c *gin.Context) {
+	task, err := h.visibleTask(principalFrom(c), c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusMultiStatus, gin.H{"error": err.Error()})
+		storeError(c, err)
+		return
 	}
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c, taskETag(task)) {
+		return
+	}
+	c.JSON(http.StatusOK, h.withBlocked(task))
 }
 
-// обработчик запроса GET /all?status=  &priority=
-func getAllTasks(c *gin.Context) {
-	// проверяем детали запроса
-	statusStr, existsStatus := c.GetQuery("status")
-	priorityStr, existsPriority := c.GetQuery("priority")
+// withBlocked заполняет вычисляемое поле Blocked одной задачи
+func (h *handlers) withBlocked(task Task) Task {
+	task.Blocked = false
+	for _, id := range task.BlockedBy {
+		blocker, err := h.store.Get(id)
+		if err == nil && !blocker.Status {
+			task.Blocked = true
+		}
+	}
+	return task
+}
 
-	// если деталей нет, то возвращаем все записи tasks и кэшируем
-	if !existsStatus && !existsPriority {
+// обработчик запроса PUT /task/:id - полная замена задачи:
+// поля, которых нет в запросе, становятся пустыми; id, seq и version менять нельзя.
+// С заголовком If-Match задача заменяется, только если не изменилась (иначе 412).
+func (h *handlers) updateTask(c *gin.Context) {
+	// проверяем параметр
+	id := c.Param("id")
 
-		// кэшируем (где?)
-		c.Header("Cache-Control", "public, max-age=3600")
-		// возвращаем все записи tasks
-		c.JSON(http.StatusOK, tasks)
+	// проверяем, есть ли задача с данным id (и видна ли она автору запроса)
+	current, err := h.visibleTask(principalFrom(c), id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	if _, ok := ifMatch(c, current); !ok {
 		return
 	}
-	// преобразовываем тип статуса из string в bool
-	status, err := strconv.ParseBool(statusStr)
+	body, err := c.GetRawData()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// преобразовываем тип приоритета из string в int (потом в uint8)
-	priority, err := strconv.Atoi(priorityStr)
+	doc, err := decodeJSON(body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// создаем временный срез для возврата отфильтрованных задач (пустой с макс. текущим объемом)
-	filterTasks := make([]Task, 0, len(tasks))
-	for _, task := range tasks {
-		if task.Status == status && task.Priority == uint8(priority) {
-			filterTasks = append(filterTasks, task)
-		}
-	}
-	c.JSON(http.StatusOK, filterTasks)
+	h.replaceTask(c, current, doc, false)
 }
 
-// обработчик запроса PUT /task/:id
-func updateTask(c *gin.Context) {
-	// проверяем параметр
+// обработчик запроса PATCH /task/:id - частичное изменение задачи.
+// Content-Type: application/merge-patch+json (RFC 7396)
+// или application/json-patch+json (RFC 6902)
+func (h *handlers) patchTask(c *gin.Context) {
 	id := c.Param("id")
 
-	// проверяем, есть ли индекс для данного id
-	i, ok := index[id]
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+	current, err := h.visibleTask(principalFrom(c), id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	if _, ok := ifMatch(c, current); !ok {
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := taskDocument(current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// накладываем патч на документ текущей задачи
+	var patched any
+	switch c.ContentType() {
+	case mergePatchType:
+		patch, err := decodeJSON(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		patched = mergePatch(doc, patch)
+	case jsonPatchType:
+		patched, err = jsonPatch(doc, body)
+		if errors.Is(err, errPatchTest) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.Header("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported patch type " + c.ContentType()})
 		return
 	}
-	// если индекс есть, то обновляем i-ю задачу по запросу
-	err := c.BindJSON(&tasks[i])
+	h.replaceTask(c, current, patched, true)
+}
+
+// replaceTask проверяет новый документ задачи и только потом сохраняет его.
+// Хранилище заменит задачу, только если ее версия все еще равна current.Version.
+func (h *handlers) replaceTask(c *gin.Context, current Task, doc any, partial bool) {
+	task, err := taskFromDocument(doc, current, partial)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	task.UpdatedBy = actorFrom(c)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// если current за это время изменилась, Update ответит errVersionMismatch
+	tasks := taskMap(h.store.List())
+	err = authorizeWrite(principalFrom(c), &task, current, tasks)
+	if err != nil {
+		accessError(c, err)
+		return
+	}
+	err = checkDependencies(task, tasks)
+	if err != nil {
+		dependencyError(c, err)
+		return
+	}
+	task, err = h.store.Update(task)
+	if errors.Is(err, errVersionMismatch) && c.GetHeader("If-Match") != "" {
+		h.preconditionFailed(c, current.ID)
+		return
+	}
+	if err != nil {
+		storeError(c, err)
+		return
+	}
 	// отправляем клиенту код завершения 200 и обновленную задачу
-	c.JSON(http.StatusOK, tasks[i])
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusOK, h.withBlocked(task))
+}
 
-	// записываем все задачи в файл
-	err = saveTasksToFile(tasks)
+// preconditionFailed отвечает 412 с ETag текущей версии задачи
+func (h *handlers) preconditionFailed(c *gin.Context, id string) {
+	task, err := h.store.Get(id)
 	if err != nil {
-		c.JSON(http.StatusMultiStatus, gin.H{"error": err.Error()})
+		storeError(c, err)
 		return
 	}
+	preconditionFailed(c, task)
 }
 
 // обработчик запроса DELETE /tasks/:id
-func deleteTask(c *gin.Context) {
+func (h *handlers) deleteTask(c *gin.Context) {
 	// проверяем параметр
 	id := c.Param("id")
 
-	// проверяем, есть ли индекс для данного id
-	i, ok := index[id]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// удалить можно только видимую задачу; с заголовком If-Match -
+	// только если она не изменилась
+	current, err := h.visibleTask(principalFrom(c), id)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	version, ok := ifMatch(c, current)
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
-	// сдвигаем срез для удаления i-й задачи
-	tasks = append(tasks[:i], tasks[i+1:]...)
-
-	// обновляем индекс
-	createIndex()
-
-	// отправляем ответ клиенту и перезаписыаем файл
-	c.JSON(http.StatusOK, gin.H{"message": "task deleted"})
 
-	// записываем срез задач в файл
-	err := saveTasksToFile(tasks)
+	// переносим задачу в корзину (хранилище же записывает изменение на диск);
+	// ребра blocked_by остаются до окончательного удаления (см. trash.go)
+	err = h.store.Delete(Task{ID: id, Version: version, UpdatedBy: actorFrom(c)})
+	if errors.Is(err, errVersionMismatch) {
+		h.preconditionFailed(c, id)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusMultiStatus, gin.H{"error": err.Error()})
+		storeError(c, err)
 		return
 	}
+	// отправляем ответ клиенту
+	c.JSON(http.StatusOK, gin.H{"message": "task moved to trash"})
 }
 
-func saveTasksToFile(tasks []Task) error {
-	jsonData, err := json.MarshalIndent(tasks, "", "\t")
-	if err != nil {
-		return err
+// обработчик запроса GET /tasks?page=
+func (h *handlers) listTasks(c *gin.Context) {
+	// размер страницы: ?limit= (по умолчанию из настроек проекта или
+	// сервера, не больше tasks.max_per_page, см. config.go)
+	cfg := currentConfig()
+	perPage := h.settings().TasksPerPage
+	if perPage == 0 {
+		perPage = cfg.Tasks.PerPage
 	}
-	err = os.WriteFile("tasks.json", jsonData, 0644)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(perPage)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	limit = min(limit, cfg.Tasks.MaxPerPage)
+
+	// Задачи упорядочены по Seq (порядку создания), и курсор хранит Seq
+	// граничной задачи, а не номер позиции в срезе. Поэтому страница
+	// не "съезжает", когда задачи добавляются или удаляются (срез сдвигается).
+	// Старый вариант ?page= (смещение) оставлен для совместимости.
+	p := principalFrom(c)
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c, collectionETag(h.epoch, h.store.Revision(), p.scope())) {
+		return
+	}
+	tasks := h.visibleTasks(p)
+	var page taskPage
+	switch {
+	case c.Query("cursor") != "":
+		cur, err := decodeCursor(c.Query("cursor"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		page = pageByCursor(tasks, cur, limit)
+	default:
+		// (если ?query не указан, то номер страницы = 1)
+		n, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+			return
+		}
+		page = pageByOffset(tasks, (n-1)*limit, limit)
+	}
+
+	markBlocked(page.Items, h.blockers(page.Items))
+	setLinkHeader(c, page, limit)
+	c.JSON(http.StatusOK, page)
+}
+
+func homePage(c *gin.Context) {
+	c.String(http.StatusOK, "СПИСОК ЗАДАЧ\n")
+}
+
+// newRouter создает gin.Engine и регистрирует маршруты
+func newRouter(h *handlers) *gin.Engine {
+	r := gin.New()
+	// по умолчанию gin верит X-Forwarded-For от любого клиента, и подделанный
+	// заголовок обходит ограничения частоты (rate.go), которые считают по IP
+	err := r.SetTrustedProxies(currentConfig().trustedProxies())
 	if err != nil {
-		return err
+		// список проверен в validate; если нет - не доверять никому
+		log.Printf("trusted proxies: %v", err)
+		_ = r.SetTrustedProxies(nil)
 	}
-	return nil
+	r.Use(h.logRequests, recordMetrics, gin.CustomRecoveryWithWriter(io.Discard, recoverPanic))
+
+	r.GET("/", homePage)
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", h.readyz)
+	r.POST("/auth/token", h.limits.rateLimit, h.issueToken)
+
+	// остальные маршруты - только с ключом или токеном и с нужной ролью (rbac.go)
+	api := r.Group("/", authenticate(h.auth), h.limits.rateLimit, h.authorize)
+	api.GET("/auth/me", h.whoAmI)
+
+	// задачи проекта default - по старым адресам, других проектов - под /projects/:pid
+	taskRoutes(api)
+	taskRoutes(api.Group(projectPrefix))
+	api.GET("/projects", h.listProjects)
+	api.POST("/projects", h.createProject)
+	api.GET("/projects/:pid", h.getProject)
+	api.PUT("/projects/:pid", h.updateProject)
+	api.DELETE("/projects/:pid", h.deleteProject)
+
+	api.POST("/webhooks", h.createWebhook)
+	api.GET("/webhooks", h.listWebhooks)
+	api.GET("/webhooks/dead", h.deadDeliveries)
+	api.POST("/webhooks/dead/:id/redeliver", h.redeliverWebhook)
+	api.DELETE("/webhooks/dead/:id", h.dropDeadDelivery)
+	api.GET("/webhooks/:id", h.getWebhook)
+	api.PUT("/webhooks/:id", h.updateWebhook)
+	api.DELETE("/webhooks/:id", h.deleteWebhook)
+	api.GET("/webhooks/:id/queue", h.webhookQueue)
+
+	api.GET("/metrics", h.serveMetrics)
+
+	return r
 }
 
-func loadTasksFromFile() error {
-	data, err := os.ReadFile("tasks.json")
-	if os.IsNotExist(err) {
-		_, err = os.Create("tasks.json")
-		if err != nil {
-			return err
-		}
-		return nil
+// taskRoutes регистрирует маршруты задач проекта в g
+// (проект запроса находит authorize)
+func taskRoutes(g gin.IRoutes) {
+	g.POST("/task", inProject((*handlers).createTask))
+	g.GET("/all", inProject((*handlers).getAllTasks))
+	g.GET("/tasks", inProject((*handlers).listTasks))
+	g.POST("/tasks/batch", inProject((*handlers).batchTasks))
+	g.GET("/task/:id", inProject((*handlers).getTask))
+	g.GET("/task/:id/graph", inProject((*handlers).taskGraph))
+	g.GET("/task/:id/history", inProject((*handlers).taskHistory))
+	g.GET("/events", inProject((*handlers).listEvents))
+	g.GET("/events/stream", inProject((*handlers).streamEvents))
+	g.PUT("/task/:id", inProject((*handlers).updateTask))
+	g.PATCH("/task/:id", inProject((*handlers).patchTask))
+	g.DELETE("/tasks/:id", inProject((*handlers).deleteTask))
+	g.GET("/search", inProject((*handlers).searchTasks))
+	g.GET("/export", inProject((*handlers).exportTasks))
+	g.POST("/import", inProject((*handlers).importTasks))
+	g.GET("/trash", inProject((*handlers).listTrash))
+	g.POST("/trash/:id/restore", inProject((*handlers).restoreTask))
+	g.DELETE("/trash/:id", inProject((*handlers).purgeTask))
+	g.DELETE("/trash", inProject((*handlers).emptyTrash))
+	// те же задачи по адресам /tasks и /tasks/:id
+	g.POST("/tasks", inProject((*handlers).createTask))
+	g.GET("/tasks/:id", inProject((*handlers).getTask))
+	g.PUT("/tasks/:id", inProject((*handlers).updateTask))
+	g.PATCH("/tasks/:id", inProject((*handlers).patchTask))
+	g.POST("/tasks/:id/move", inProject((*handlers).moveTask))
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		log.Fatal(err)
 	}
+}
+
+// run запускает сервер и работает до SIGINT/SIGTERM. Ошибки возвращаются,
+// а не завершают процесс, чтобы defer закрыли уже открытые хранилища
+// и остановили сервер
+func run() error {
+	hashPass := flag.Bool("hash-password", false, "print a bcrypt hash of the password read from stdin and exit")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration as TOML and exit")
+	// настройки: флаги, окружение, файл TOML (см. config.go)
+	loader, err := newConfigLoader(flag.CommandLine, os.Args[1:], os.Getenv)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, &tasks)
+	cfg, err := loader.load()
 	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	config.Store(&cfg)
+	if *printConfig {
+		data, err := cfg.printConfig()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
 		return err
 	}
-	return nil
-}
 
-// обработчик запроса GET /tasks?page=
-func listTasks(c *gin.Context) {
-	// (если ?query не указан, то номер страницы = 1)
-	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	// журнал: запросы (logging.go) и фоновые сообщения log.Printf;
+	// уровень меняется по SIGHUP
+	var level slog.LevelVar
+	logger, err := newLogger(os.Stderr, cfg.Log.Format, &level)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return err
 	}
+	slog.SetDefault(logger)
+	applyConfig(&level, nil)
 
-	// выбираем задачи для "нужной" страницы вывода (с номером page из запроса или 1 по умолчанию)...
-	// вопрос в другом - по какому критерию выбирать?
-	// предполагается, что задачи сортируются по ID.
-	// В нашем случае ID имеет строковый тип и не является индексом
-	// (имеет строго случайный порядок - по двум причинам:
-	// 1) UUID генерируются случайным образом
-	// 2) элементы карты не сортируются в принципе).
+	if *hashPass {
+		err := hashPassword()
+		if err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+		return nil
+	}
 
-	// Таким образом, для пагинации надо создать индекс (вспомогательную карту
-	// с UUID в качестве ключа и целочисленным индексом (i) в качестве значения)...
-	// При каждом создании или удалении задачи индекс надо будет обновлять.
+	// порт открывается сразу: пока задачи загружаются, сервер отвечает
+	// только на проверки (health.go); SIGINT/SIGTERM - плавная остановка,
+	// SIGHUP - перечитать настройки (и во время загрузки не завершает процесс)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	hup, stopHup := notifyHangup()
+	defer stopHup()
+	srv := newServer(cfg.Addr)
+	defer srv.Close()
+	listenErr := make(chan error, 1)
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listenErr <- err
+		}
+	}()
 
-	// страница формируется сразу из среза задач по нижнему и верхнему индексу
-	iL := (page - 1) * tasksPerPage
-	if iL >= len(tasks) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "на этой странице нет задач"})
-		return
-	}
-	iH := page * tasksPerPage
-	if iH > len(tasks) {
-		iH = len(tasks)
+	// хранилище задач: снимок tasks.json + журнал изменений tasks.wal
+	// (можно заменить на newFileStore("tasks.json") или newMemoryStore(nil));
+	// при выходе журнал сжимается в снимок
+	store, report, err := newWALStore(cfg.Storage.Tasks, cfg.Storage.WAL)
+	if err != nil {
+		return fmt.Errorf("load tasks: %w", err)
 	}
-	c.JSON(http.StatusOK, tasks[iL:iH])
-}
-func homePage(c *gin.Context) {
-	c.String(http.StatusOK, "СПИСОК ЗАДАЧ\n")
-}
+	log.Printf("load tasks: %s", report)
+	defer closeOnExit("tasks", store)
 
-func main() {
-	err := loadTasksFromFile()
+	// история изменений задач
+	events, err := newEventLog(cfg.Storage.History)
 	if err != nil {
-		return
+		return fmt.Errorf("load history: %w", err)
 	}
+	defer closeOnExit("history", events)
 
-	// обновляем индекс
-	createIndex()
+	// подписки на события и очередь их доставки
+	hooks, err := newWebhookDispatcher(cfg.Storage.Webhooks)
+	if err != nil {
+		return fmt.Errorf("load webhooks: %w", err)
+	}
 
-	r := gin.Default()
+	// ключи и пользователи
+	auth, err := newAuthenticator(cfg.Auth.File)
+	if err != nil {
+		return fmt.Errorf("load auth: %w", err)
+	}
 
-	r.GET("/", homePage)
-	r.POST("/task", createTask)
-	r.GET("/all", getAllTasks)
-	r.GET("/tasks", listTasks)
-	r.PUT("/task/:id", updateTask)
-	r.DELETE("/tasks/:id", deleteTask)
+	h := newHandlers(store, events, hooks, auth)
+	h.limits = newRateLimiter(cfg.readClass(), cfg.writeClass())
+	// проекты: список в projects.json, задачи каждого - в projects/<id>/
+	projects, err := newProjectSet(cfg.Storage.Projects, cfg.Storage.ProjectsDir, h)
+	if err != nil {
+		return fmt.Errorf("load projects: %w", err)
+	}
+	defer closeOnExit("projects", projects)
+
+	// фоновые задачи: доставка вебхуков, удаление задач, пролежавших
+	// в корзине дольше tasks.trash_retention, и перечитывание настроек
+	// по SIGHUP; останавливаются по сигналу
+	var background sync.WaitGroup
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		hooks.run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		h.sweepTrash(ctx, trashSweepEvery)
+	}()
+	go func() {
+		defer background.Done()
+		reloadOnHangup(ctx, hup, loader, &level, h.limits)
+	}()
+
+	srv.setRouter(newRouter(h))
+	log.Printf("listening on %s", cfg.Addr)
+
+	select {
+	case <-ctx.Done():
+	case err = <-listenErr:
+		stop()
+		background.Wait()
+		return fmt.Errorf("listen: %w", err)
+	}
+	stop() // повторный сигнал завершает процесс сразу
+	log.Printf("shutting down")
+	h.draining.Store(true)
+	// балансировщик замечает 503 от /readyz не сразу: пока он не перестал
+	// слать запросы, сервер продолжает их принимать
+	time.Sleep(time.Duration(currentConfig().DrainDelay))
+	err = srv.shutdown(time.Duration(currentConfig().ShutdownTimeout))
+	if err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	background.Wait()
+	// дальше defer закрывают проекты, историю и хранилище
+	return nil
+}
 
-	err = r.Run(":8080")
+// closeOnExit закрывает c при выходе из main и сообщает об ошибке
+func closeOnExit(name string, c io.Closer) {
+	err := c.Close()
 	if err != nil {
-		return
+		log.Printf("close %s: %v", name, err)
 	}
 }
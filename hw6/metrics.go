@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Метрики: GET /metrics в текстовом формате Prometheus (version 0.0.4),
+// без клиентской библиотеки.
+//
+//	http_requests_total{method,route,status}                    - запросы
+//	http_request_duration_seconds{method,route,status}          - время ответа (гистограмма)
+//	hw6_tasks{project,status,priority}                          - задачи (status: open, done)
+//	hw6_trash_tasks{project}                                    - задачи в корзине
+//	hw6_store_write_duration_seconds{store}                     - запись на диск (file, wal, snapshot)
+//	hw6_store_write_failures_total{store}                       - неудачные записи
+//	go_goroutines, go_memstats_*, go_gc_cycles_total, go_info   - среда выполнения
+//	process_start_time_seconds
+//
+// route - шаблон маршрута gin ("/task/:id"), для неизвестных адресов -
+// "unmatched", чтобы число рядов не зависело от клиентов. Счетчики
+// и гистограммы общие для сервера (metrics), задачи и среда выполнения
+// считаются при каждом запросе /metrics.
+
+// metricsContentType - Content-Type ответа /metrics
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// durationBuckets - границы гистограмм времени (секунды)
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricFamily - счетчик или гистограмма с метками
+type metricFamily struct {
+	name, help string
+	histogram  bool
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries // значения меток через "\xff"
+}
+
+// metricSeries - ряд с одним набором значений меток
+type metricSeries struct {
+	values []string
+	value  float64  // счетчик; у гистограммы - сумма наблюдений
+	counts []uint64 // гистограмма: наблюдения в каждом интервале (не нарастающим итогом)
+	count  uint64
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	f := newCounter(name, help, labels...)
+	f.histogram, f.buckets = true, buckets
+	return f
+}
+
+// get - ряд с метками values (вызывается под f.mu)
+func (f *metricFamily) get(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{values: values}
+		if f.histogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// inc увеличивает счетчик на 1
+func (f *metricFamily) inc(values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(values).value++
+}
+
+// observe добавляет наблюдение v в гистограмму
+func (f *metricFamily) observe(v float64, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.get(values)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(f.buckets, v); i < len(f.buckets) {
+		s.counts[i]++
+	}
+}
+
+// write выводит ряды в текстовом формате
+func (f *metricFamily) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	typ := "counter"
+	if f.histogram {
+		typ = "histogram"
+	}
+	writeHeader(w, f.name, f.help, typ)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if !f.histogram {
+			writeSample(w, f.name, f.labels, s.values, s.value)
+			continue
+		}
+		labels := append(append([]string{}, f.labels...), "le")
+		var total uint64
+		for i, le := range f.buckets {
+			total += s.counts[i]
+			writeSample(w, f.name+"_bucket", labels, append(append([]string{}, s.values...), formatFloat(le)), float64(total))
+		}
+		writeSample(w, f.name+"_bucket", labels, append(append([]string{}, s.values...), "+Inf"), float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.values, s.value)
+		writeSample(w, f.name+"_count", f.labels, s.values, float64(s.count))
+	}
+}
+
+// writeHeader выводит строки HELP и TYPE
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+// writeSample выводит одно значение
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, labelEscaper.Replace(values[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+// labelEscaper экранирует значение метки
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat - число в формате Prometheus
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serverMetrics - счетчики и гистограммы сервера
+type serverMetrics struct {
+	requests      *metricFamily
+	latency       *metricFamily
+	writes        *metricFamily
+	writeFailures *metricFamily
+	start         time.Time
+}
+
+// metrics - метрики этого процесса
+var metrics = &serverMetrics{
+	requests: newCounter("http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status"),
+	latency: newHistogram("http_request_duration_seconds", "HTTP request latency by method, route and status.",
+		durationBuckets, "method", "route", "status"),
+	writes: newHistogram("hw6_store_write_duration_seconds", "Time spent writing tasks to disk.",
+		durationBuckets, "store"),
+	writeFailures: newCounter("hw6_store_write_failures_total", "Failed writes of tasks to disk.", "store"),
+	start:         time.Now(),
+}
+
+// observeWrite выполняет запись на диск write и учитывает ее время и ошибку
+// (store - file, wal или snapshot)
+func observeWrite(store string, write func() error) error {
+	start := time.Now()
+	err := write()
+	metrics.writes.observe(time.Since(start).Seconds(), store)
+	if err != nil {
+		metrics.writeFailures.inc(store)
+	}
+	return err
+}
+
+// recordMetrics - middleware, которая считает запросы и время ответа
+func recordMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	metrics.requests.inc(c.Request.Method, route, status)
+	metrics.latency.observe(time.Since(start).Seconds(), c.Request.Method, route, status)
+}
+
+// обработчик запроса GET /metrics
+func (h *handlers) serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", metricsContentType)
+	c.Status(http.StatusOK)
+	w := bufio.NewWriter(c.Writer)
+	defer w.Flush()
+
+	metrics.requests.write(w)
+	metrics.latency.write(w)
+	h.writeTaskMetrics(w)
+	metrics.writes.write(w)
+	metrics.writeFailures.write(w)
+	writeRuntimeMetrics(w)
+}
+
+// writeTaskMetrics выводит число задач во всех проектах
+func (h *handlers) writeTaskMetrics(w io.Writer) {
+	type taskKey struct {
+		project, status string
+		priority        uint8
+	}
+	counts := make(map[taskKey]int)
+	trash := make(map[string]int)
+	all, release := h.projects.all()
+	defer release()
+	for _, ph := range all {
+		for _, task := range ph.store.List() {
+			status := "open"
+			if task.Status {
+				status = "done"
+			}
+			counts[taskKey{ph.project, status, task.Priority}]++
+		}
+		trash[ph.project] = len(ph.store.Trash())
+	}
+
+	keys := make([]taskKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.project != b.project {
+			return a.project < b.project
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.priority < b.priority
+	})
+	writeHeader(w, "hw6_tasks", "Tasks by project, status and priority.", "gauge")
+	for _, key := range keys {
+		writeSample(w, "hw6_tasks", []string{"project", "status", "priority"},
+			[]string{key.project, key.status, strconv.Itoa(int(key.priority))}, float64(counts[key]))
+	}
+
+	projects := make([]string, 0, len(trash))
+	for project := range trash {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	writeHeader(w, "hw6_trash_tasks", "Tasks in the trash by project.", "gauge")
+	for _, project := range projects {
+		writeSample(w, "hw6_trash_tasks", []string{"project"}, []string{project}, float64(trash[project]))
+	}
+}
+
+// writeRuntimeMetrics выводит метрики среды выполнения Go
+func writeRuntimeMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys)},
+		{"go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC) / 1e9},
+		{"process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(metrics.start.UnixNano()) / 1e9},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, g.help, "gauge")
+		writeSample(w, g.name, nil, nil, g.value)
+	}
+	writeHeader(w, "go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter")
+	writeSample(w, "go_memstats_alloc_bytes_total", nil, nil, float64(ms.TotalAlloc))
+	writeHeader(w, "go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+	writeSample(w, "go_gc_cycles_total", nil, nil, float64(ms.NumGC))
+	writeHeader(w, "go_gc_pause_seconds_total", "Total time the world was stopped for garbage collection.", "counter")
+	writeSample(w, "go_gc_pause_seconds_total", nil, nil, float64(ms.PauseTotalNs)/1e9)
+	writeHeader(w, "go_info", "Information about the Go environment.", "gauge")
+	writeSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, 1)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleLine - строка значения в текстовом формате Prometheus
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[^}]*\})? (\S+)$`)
+
+// histogramSuffix - окончания рядов гистограммы
+var histogramSuffix = regexp.MustCompile(`_(bucket|sum|count)$`)
+
+// scrapeMetrics запрашивает /metrics и возвращает значения по "имя{метки}"
+func scrapeMetrics(t *testing.T, r http.Handler) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != metricsContentType {
+		t.Fatalf("/metrics: %d %v", w.Code, w.Header())
+	}
+	samples := make(map[string]float64)
+	typed := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			typed[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("bad line %q", line)
+		}
+		base := histogramSuffix.ReplaceAllString(m[1], "")
+		if !typed[m[1]] && !typed[base] {
+			t.Fatalf("no TYPE before %q", line)
+		}
+		v, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Fatalf("bad value in %q", line)
+		}
+		samples[m[1]+m[2]] = v
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileStore(filepath.Join(dir, "tasks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, store)
+	do := func(method, url string, body any) { s.do("", method, url, body) }
+	before := scrapeMetrics(t, s.r)
+
+	do(http.MethodPost, "/task", Task{Title: "a", Priority: 2})
+	do(http.MethodPost, "/task", Task{Title: "b", Priority: 2, Status: true})
+	do(http.MethodGet, "/task/missing", nil)
+	do(http.MethodGet, "/no/such/route", nil)
+	_ = os.RemoveAll(dir)
+	do(http.MethodPost, "/task", Task{Title: "c"}) // файл не записан
+
+	m := scrapeMetrics(t, s.r)
+	delta := func(key string) float64 { return m[key] - before[key] }
+	for key, want := range map[string]float64{
+		`http_requests_total{method="POST",route="/task",status="200"}`:                            2,
+		`http_requests_total{method="POST",route="/task",status="207"}`:                            1,
+		`http_requests_total{method="GET",route="/task/:id",status="404"}`:                         1,
+		`http_requests_total{method="GET",route="unmatched",status="404"}`:                         1,
+		`http_request_duration_seconds_count{method="POST",route="/task",status="200"}`:            2,
+		`http_request_duration_seconds_bucket{method="POST",route="/task",status="200",le="+Inf"}`: 2,
+		`hw6_store_write_duration_seconds_count{store="file"}`:                                     3,
+		`hw6_store_write_failures_total{store="file"}`:                                             1,
+	} {
+		if got := delta(key); got != want {
+			t.Errorf("%s: +%v, want +%v", key, got, want)
+		}
+	}
+	for key, want := range map[string]float64{
+		`hw6_tasks{project="default",status="open",priority="2"}`: 1,
+		`hw6_tasks{project="default",status="done",priority="2"}`: 1,
+		`hw6_tasks{project="default",status="open",priority="0"}`: 1,
+		`hw6_trash_tasks{project="default"}`:                      0,
+	} {
+		if got, ok := m[key]; !ok || got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if m["go_goroutines"] < 1 || m["go_memstats_alloc_bytes"] <= 0 || m["process_start_time_seconds"] <= 0 {
+		t.Errorf("runtime metrics: %v %v %v", m["go_goroutines"], m["go_memstats_alloc_bytes"], m["process_start_time_seconds"])
+	}
+
+	// гистограмма - нарастающим итогом
+	prev := -1.0
+	for _, le := range append(append([]float64{}, durationBuckets...), math.Inf(1)) {
+		v := m[`http_request_duration_seconds_bucket{method="POST",route="/task",status="200",le="`+formatFloat(le)+`"}`]
+		if v < prev {
+			t.Fatalf("bucket le=%v = %v < %v", le, v, prev)
+		}
+		prev = v
+	}
+}
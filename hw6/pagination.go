@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errBadCursor - курсор не удалось разобрать
+var errBadCursor = errors.New("invalid cursor")
+
+// pageCursor - граница страницы: задачи после After или до Before (по Seq).
+// Клиенту курсор отдается непрозрачной строкой (base64 от JSON).
+type pageCursor struct {
+	After  uint64 `json:"a,omitempty"`
+	Before uint64 `json:"b,omitempty"`
+}
+
+func (cur pageCursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var cur pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, errBadCursor
+	}
+	err = json.Unmarshal(data, &cur)
+	if err != nil || (cur.After == 0) == (cur.Before == 0) {
+		return pageCursor{}, errBadCursor
+	}
+	return cur, nil
+}
+
+// taskPage - ответ GET /tasks: задачи страницы, общее число задач и курсоры соседних страниц
+type taskPage struct {
+	Items      []Task `json:"items"`
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// newTaskPage заполняет курсоры для задач tasks[lo:hi]
+func newTaskPage(tasks []Task, lo, hi, limit int) taskPage {
+	page := taskPage{Items: tasks[lo:hi], Total: len(tasks), Limit: limit}
+	if page.Items == nil {
+		page.Items = []Task{}
+	}
+	if hi < len(tasks) && hi > 0 {
+		page.NextCursor = pageCursor{After: tasks[hi-1].Seq}.encode()
+	}
+	if lo > 0 {
+		// предыдущая страница заканчивается задачей tasks[lo-1] включительно
+		page.PrevCursor = pageCursor{Before: tasks[lo-1].Seq + 1}.encode()
+	}
+	return page
+}
+
+// pageByOffset - страница по смещению (старый вариант ?page=);
+// за последней страницей возвращается пустая, а не ошибка
+func pageByOffset(tasks []Task, offset, limit int) taskPage {
+	lo := min(offset, len(tasks))
+	hi := min(lo+limit, len(tasks))
+	return newTaskPage(tasks, lo, hi, limit)
+}
+
+// pageByCursor - страница после (или до) задачи с Seq из курсора.
+// tasks упорядочены по Seq, поэтому граница ищется двоичным поиском
+// и не зависит от того, удалена ли сама граничная задача.
+func pageByCursor(tasks []Task, cur pageCursor, limit int) taskPage {
+	if cur.After != 0 {
+		lo := sort.Search(len(tasks), func(i int) bool { return tasks[i].Seq > cur.After })
+		return newTaskPage(tasks, lo, min(lo+limit, len(tasks)), limit)
+	}
+	hi := sort.Search(len(tasks), func(i int) bool { return tasks[i].Seq >= cur.Before })
+	return newTaskPage(tasks, max(hi-limit, 0), hi, limit)
+}
+
+// setLinkHeader добавляет заголовок Link (RFC 8288) со ссылками first/next/prev
+func setLinkHeader(c *gin.Context, page taskPage, limit int) {
+	link := func(rel, cursor string) string {
+		query := c.Request.URL.Query()
+		query.Del("page")
+		query.Del("cursor")
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		query.Set("limit", strconv.Itoa(limit))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Request.URL.Path, query.Encode(), rel)
+	}
+
+	links := []string{link("first", "")}
+	if page.NextCursor != "" {
+		links = append(links, link("next", page.NextCursor))
+	}
+	if page.PrevCursor != "" {
+		links = append(links, link("prev", page.PrevCursor))
+	}
+	c.Header("Link", strings.Join(links, ", "))
+}
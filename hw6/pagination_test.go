@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCursorPagination(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, title := range []string{"t1", "t2", "t3", "t4", "t5", "t6", "t7"} {
+		s.ok("", http.MethodPost, "/task", Task{Title: title}, nil)
+	}
+	tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil))
+	titles := func(page taskPage) string {
+		var list []string
+		for _, task := range page.Items {
+			list = append(list, task.Title)
+		}
+		return strings.Join(list, ",")
+	}
+
+	var first taskPage
+	w := s.do("", http.MethodGet, "/tasks?limit=3", nil)
+	decodeBody(t, w, &first)
+	if titles(first) != "t1,t2,t3" || first.Total != 7 || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "cursor="+first.NextCursor) {
+		t.Fatalf("Link = %q", link)
+	}
+
+	// между страницами удалены задачи первой страницы (и граничная)
+	// и добавлена новая: следующая страница не теряет и не повторяет задач
+	s.ok("", http.MethodDelete, "/tasks/"+tasks[1].ID, nil, nil)
+	s.ok("", http.MethodDelete, "/tasks/"+tasks[2].ID, nil, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "t8"}, nil)
+	var second taskPage
+	s.ok("", http.MethodGet, "/tasks?limit=3&cursor="+first.NextCursor, nil, &second)
+	if titles(second) != "t4,t5,t6" || second.Total != 6 || second.PrevCursor == "" {
+		t.Fatalf("second page = %+v", second)
+	}
+	// со смещением та же страница съехала бы и пропустила t4
+	var byOffset taskPage
+	s.ok("", http.MethodGet, "/tasks?limit=3&page=2", nil, &byOffset)
+	if titles(byOffset) != "t6,t7,t8" {
+		t.Fatalf("offset page = %+v", byOffset)
+	}
+
+	var prev, last taskPage
+	s.ok("", http.MethodGet, "/tasks?limit=3&cursor="+second.PrevCursor, nil, &prev)
+	if titles(prev) != "t1" || prev.PrevCursor != "" {
+		t.Fatalf("previous page = %+v", prev)
+	}
+	s.ok("", http.MethodGet, "/tasks?limit=3&cursor="+second.NextCursor, nil, &last)
+	if titles(last) != "t7,t8" || last.NextCursor != "" {
+		t.Fatalf("last page = %+v", last)
+	}
+}
+
+func TestPaginationErrors(t *testing.T) {
+	defer config.Store(currentConfig())
+	cfg := defaultConfig()
+	cfg.Tasks.MaxPerPage = 4
+	config.Store(&cfg)
+
+	s := newTestServer(t, nil)
+	for i := 0; i < 6; i++ {
+		s.ok("", http.MethodPost, "/task", Task{Title: "t"}, nil)
+	}
+
+	// ?limit= не больше tasks.max_per_page
+	var page taskPage
+	s.ok("", http.MethodGet, "/tasks?limit=1000", nil, &page)
+	if page.Limit != 4 || len(page.Items) != 4 {
+		t.Fatalf("capped page = %+v", page)
+	}
+
+	cursor := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+	for _, query := range []string{
+		"limit=0",
+		"limit=x",
+		"page=0",
+		"cursor=not*base64",
+		"cursor=" + cursor(`not json`),
+		"cursor=" + cursor(`{}`),
+		"cursor=" + cursor(`{"a":1,"b":2}`),
+		"cursor=" + cursor(`{"a":-1}`),
+	} {
+		if w := s.do("", http.MethodGet, "/tasks?"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", query, w.Code, w.Body)
+		}
+	}
+}
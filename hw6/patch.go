@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// типы содержимого для PATCH /task/:id
+const (
+	mergePatchType = "application/merge-patch+json" // RFC 7396
+	jsonPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// errPatchTest - не выполнилась операция "test" JSON Patch
+var errPatchTest = errors.New("json patch: test failed")
+
+// decodeJSON разбирает JSON в map/slice/json.Number (числа не теряют точность)
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// mergePatch применяет JSON Merge Patch (RFC 7396):
+// объекты сливаются по ключам, null удаляет ключ, остальное заменяется целиком
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// patchOp - одна операция JSON Patch (RFC 6902)
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// jsonPatch применяет операции JSON Patch к документу doc.
+// Операции применяются по порядку; при любой ошибке результат не возвращается.
+func jsonPatch(doc any, data []byte) (any, error) {
+	var ops []patchOp
+	err := json.Unmarshal(data, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("json patch: %w", err)
+	}
+	for i, op := range ops {
+		doc, err = applyPatchOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(doc any, op patchOp) (any, error) {
+	if op.Path == nil {
+		return nil, errors.New("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("missing value")
+		}
+		value, err = decodeJSON(*op.Value)
+		if err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errors.New("missing from")
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err = pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, errors.New("cannot move a value into its own child")
+			}
+			doc, err = pointerRemove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// копия не должна разделять map/slice с оригиналом
+			value = deepCopy(value)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		// замена корня - весь документ целиком
+		if len(path) == 0 {
+			return value, nil
+		}
+		_, err = pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = pointerRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, errPatchTest
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer разбирает JSON Pointer (RFC 6901) в список ключей
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer %q", s)
+	}
+	parts := strings.Split(s[1:], "/")
+	for i, p := range parts {
+		parts[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(p)
+	}
+	return parts, nil
+}
+
+// arrayIndex разбирает индекс массива; "-" (конец) разрешен только для add
+func arrayIndex(key string, n int, allowEnd bool) (int, error) {
+	if key == "-" && allowEnd {
+		return n, nil
+	}
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (key != "0" && key[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	if i > n || (i == n && !allowEnd) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, key := range path {
+		switch v := doc.(type) {
+		case map[string]any:
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("path /%s not found", key)
+			}
+			doc = next
+		case []any:
+			i, err := arrayIndex(key, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = v[i]
+		default:
+			return nil, fmt.Errorf("path /%s not found", key)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd добавляет value по пути и возвращает новый корень документа
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch v := parent.(type) {
+	case map[string]any:
+		v[key] = value
+		return doc, nil
+	case []any:
+		i, err := arrayIndex(key, len(v), true)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v[:i], append([]any{value}, v[i:]...)...)
+		return pointerSet(doc, path[:len(path)-1], v)
+	}
+	return nil, fmt.Errorf("path /%s not found", strings.Join(path, "/"))
+}
+
+// pointerRemove удаляет значение по пути и возвращает новый корень документа
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch v := parent.(type) {
+	case map[string]any:
+		if _, ok := v[key]; !ok {
+			return nil, fmt.Errorf("path /%s not found", strings.Join(path, "/"))
+		}
+		delete(v, key)
+		return doc, nil
+	case []any:
+		i, err := arrayIndex(key, len(v), false)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v[:i:i], v[i+1:]...)
+		return pointerSet(doc, path[:len(path)-1], v)
+	}
+	return nil, fmt.Errorf("path /%s not found", strings.Join(path, "/"))
+}
+
+// pointerSet заменяет значение по существующему пути (нужно для срезов,
+// у которых после append меняется заголовок)
+func pointerSet(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch v := parent.(type) {
+	case map[string]any:
+		v[key] = value
+	case []any:
+		i, err := arrayIndex(key, len(v), false)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = value
+	}
+	return doc, nil
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, x := range v {
+			m[k] = deepCopy(x)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, x := range v {
+			s[i] = deepCopy(x)
+		}
+		return s
+	}
+	return v
+}
+
+// jsonEqual сравнивает значения JSON; числа сравниваются по значению (1 == 1.0)
+func jsonEqual(a, b any) bool {
+	na, okA := a.(json.Number)
+	nb, okB := b.(json.Number)
+	if okA && okB {
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// immutableFields - поля задачи, которые клиент не может менять (их задает сервер)
+var immutableFields = []string{"id", "seq", "version", "created_at", "updated_at", "completed_at", "updated_by", "deleted_at"}
+
+// derivedFields - поля, которые сервер вычисляет при ответе (клиент может их
+// прислать обратно, но они игнорируются)
+var derivedFields = []string{"blocked"}
+
+// taskDocument - задача в виде документа JSON для наложения патча.
+// В документе есть все поля задачи (в том числе пустые, скрытые omitempty),
+// чтобы операции replace/test JSON Patch работали и с ними.
+func taskDocument(task Task) (map[string]any, error) {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	doc := v.(map[string]any)
+
+	t := reflect.TypeOf(task)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		// пустой список - [], а не null: иначе в него нельзя добавить "/поле/-"
+		if t.Field(i).Type.Kind() == reflect.Slice && doc[name] == nil {
+			doc[name] = []any{}
+			continue
+		}
+		if _, ok := doc[name]; ok {
+			continue
+		}
+		zero, err := json.Marshal(reflect.Zero(t.Field(i).Type).Interface())
+		if err != nil {
+			return nil, err
+		}
+		doc[name], _ = decodeJSON(zero)
+	}
+	return doc, nil
+}
+
+// taskFromDocument проверяет документ (результат PUT или PATCH) и превращает
+// его в задачу: неизменяемые поля должны совпадать с current (для PUT их можно
+// не передавать), неизвестные поля запрещены, обязательные поля проверяются
+// теми же правилами binding, что и при создании задачи.
+func taskFromDocument(doc any, current Task, partial bool) (Task, error) {
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return Task{}, errors.New("task must be a JSON object")
+	}
+	before, err := taskDocument(current)
+	if err != nil {
+		return Task{}, err
+	}
+	for _, field := range immutableFields {
+		v, ok := m[field]
+		if !ok && !partial {
+			continue
+		}
+		if !ok || !jsonEqual(v, before[field]) {
+			return Task{}, fmt.Errorf("field %q is immutable", field)
+		}
+	}
+
+	// вычисляемые поля не принимаются от клиента
+	for _, field := range derivedFields {
+		delete(m, field)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Task{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var task Task
+	err = dec.Decode(&task)
+	if err != nil {
+		return Task{}, err
+	}
+	task.ID = current.ID
+	task.Seq = current.Seq
+	task.Version = current.Version
+	task.CreatedAt, task.UpdatedAt, task.CompletedAt = current.CreatedAt, current.UpdatedAt, current.CompletedAt
+	task.UpdatedBy = current.UpdatedBy
+	task.DeletedAt = current.DeletedAt
+	// [] из документа хранится как пустой список, как и у новой задачи
+	if len(task.SharedWith) == 0 {
+		task.SharedWith = nil
+	}
+	if len(task.BlockedBy) == 0 {
+		task.BlockedBy = nil
+	}
+
+	err = binding.Validator.ValidateStruct(&task)
+	if err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
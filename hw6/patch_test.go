@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONPatch(t *testing.T) {
+	const doc = `{"a":1,"b":{"c":"x"},"list":[1,2],"empty":[]}`
+	for _, tc := range []struct{ name, patch, want string }{
+		{"add field", `[{"op":"add","path":"/d","value":true}]`, `{"a":1,"b":{"c":"x"},"list":[1,2],"empty":[],"d":true}`},
+		{"add replaces field", `[{"op":"add","path":"/a","value":2}]`, `{"a":2,"b":{"c":"x"},"list":[1,2],"empty":[]}`},
+		{"add to array", `[{"op":"add","path":"/list/1","value":9}]`, `{"a":1,"b":{"c":"x"},"list":[1,9,2],"empty":[]}`},
+		{"add to array end", `[{"op":"add","path":"/list/-","value":3}]`, `{"a":1,"b":{"c":"x"},"list":[1,2,3],"empty":[]}`},
+		{"add to empty array", `[{"op":"add","path":"/empty/-","value":"x"}]`, `{"a":1,"b":{"c":"x"},"list":[1,2],"empty":["x"]}`},
+		{"add root", `[{"op":"add","path":"","value":{"z":0}}]`, `{"z":0}`},
+		{"remove field", `[{"op":"remove","path":"/b/c"}]`, `{"a":1,"b":{},"list":[1,2],"empty":[]}`},
+		{"remove from array", `[{"op":"remove","path":"/list/0"}]`, `{"a":1,"b":{"c":"x"},"list":[2],"empty":[]}`},
+		{"replace field", `[{"op":"replace","path":"/b/c","value":"y"}]`, `{"a":1,"b":{"c":"y"},"list":[1,2],"empty":[]}`},
+		{"replace array item", `[{"op":"replace","path":"/list/1","value":5}]`, `{"a":1,"b":{"c":"x"},"list":[1,5],"empty":[]}`},
+		{"replace root", `[{"op":"replace","path":"","value":{"z":0}}]`, `{"z":0}`},
+		{"move", `[{"op":"move","from":"/b/c","path":"/c"}]`, `{"a":1,"b":{},"c":"x","list":[1,2],"empty":[]}`},
+		{"move in array", `[{"op":"move","from":"/list/0","path":"/list/-"}]`, `{"a":1,"b":{"c":"x"},"list":[2,1],"empty":[]}`},
+		{"copy", `[{"op":"copy","from":"/b","path":"/e"}]`, `{"a":1,"b":{"c":"x"},"e":{"c":"x"},"list":[1,2],"empty":[]}`},
+		{"test", `[{"op":"test","path":"/a","value":1.0},{"op":"test","path":"","value":` + doc + `}]`, doc},
+		{"escaped pointer", `[{"op":"add","path":"/x~1y~0z","value":1}]`, `{"a":1,"b":{"c":"x"},"list":[1,2],"empty":[],"x/y~z":1}`},
+		{"in order", `[{"op":"add","path":"/n","value":1},{"op":"replace","path":"/n","value":2},{"op":"test","path":"/n","value":2}]`, `{"a":1,"b":{"c":"x"},"list":[1,2],"empty":[],"n":2}`},
+	} {
+		got := applyTestPatch(t, doc, tc.patch)
+		if got != canonicalJSON(t, tc.want) {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	const doc = `{"a":1,"list":[1,2]}`
+	for _, tc := range []struct{ name, patch, err string }{
+		{"not a list", `{"op":"add"}`, "json patch"},
+		{"unknown op", `[{"op":"merge","path":"/a","value":1}]`, "merge"},
+		{"missing path", `[{"op":"remove"}]`, "missing path"},
+		{"missing value", `[{"op":"add","path":"/a"}]`, "missing value"},
+		{"missing from", `[{"op":"move","path":"/a"}]`, "missing from"},
+		{"bad pointer", `[{"op":"remove","path":"a"}]`, "invalid pointer"},
+		{"no parent", `[{"op":"add","path":"/x/y","value":1}]`, "not found"},
+		{"remove missing", `[{"op":"remove","path":"/x"}]`, "not found"},
+		{"remove root", `[{"op":"remove","path":""}]`, "whole document"},
+		{"replace missing", `[{"op":"replace","path":"/x","value":1}]`, "not found"},
+		{"array index", `[{"op":"add","path":"/list/3","value":1}]`, "out of range"},
+		{"remove array end", `[{"op":"remove","path":"/list/-"}]`, "invalid array index"},
+		{"move into itself", `[{"op":"move","from":"","path":"/a"}]`, "own child"},
+		{"test failed", `[{"op":"test","path":"/a","value":2}]`, "test failed"},
+		// при ошибке не применяется ни одна операция
+		{"second op", `[{"op":"add","path":"/b","value":1},{"op":"test","path":"/b","value":2}]`, "operation 1"},
+	} {
+		v, err := decodeJSON([]byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := jsonPatch(v, []byte(tc.patch))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: %v, %v, want error %q", tc.name, got, err, tc.err)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	const doc = `{"a":1,"b":{"c":"x","d":"y"},"list":[1,2]}`
+	for _, tc := range []struct{ name, patch, want string }{
+		{"set", `{"a":2,"e":"z"}`, `{"a":2,"b":{"c":"x","d":"y"},"list":[1,2],"e":"z"}`},
+		{"null removes", `{"a":null,"b":{"c":null}}`, `{"b":{"d":"y"},"list":[1,2]}`},
+		{"null for missing", `{"x":null}`, doc},
+		{"arrays replaced", `{"list":[3]}`, `{"a":1,"b":{"c":"x","d":"y"},"list":[3]}`},
+		{"object replaces value", `{"a":{"n":null,"m":1}}`, `{"a":{"m":1},"b":{"c":"x","d":"y"},"list":[1,2]}`},
+		{"not an object", `[1]`, `[1]`},
+	} {
+		target, _ := decodeJSON([]byte(doc))
+		patch, err := decodeJSON([]byte(tc.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(mergePatch(target, patch))
+		if string(got) != canonicalJSON(t, tc.want) {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPatchTask(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "a"}, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "b"}, nil)
+	tasks := decodeTasks(t, s.do("", http.MethodGet, "/all", nil))
+	a, b := tasks[0], tasks[1]
+
+	jsonPatchReq := func(id, ops string) (Task, int) {
+		t.Helper()
+		w := s.do("", http.MethodPatch, "/task/"+id, json.RawMessage(ops), "Content-Type", jsonPatchType)
+		var task Task
+		if w.Code == http.StatusOK {
+			decodeBody(t, w, &task)
+		}
+		return task, w.Code
+	}
+
+	// пустые списки - [], поэтому в них можно добавлять в конец
+	task, code := jsonPatchReq(a.ID, `[{"op":"add","path":"/blocked_by/-","value":"`+b.ID+`"},{"op":"add","path":"/shared_with/-","value":"bob"}]`)
+	if code != http.StatusOK || len(task.BlockedBy) != 1 || task.BlockedBy[0] != b.ID || len(task.SharedWith) != 1 {
+		t.Fatalf("add to empty lists: %d %+v", code, task)
+	}
+	task, code = jsonPatchReq(a.ID, `[{"op":"remove","path":"/blocked_by/0"},{"op":"test","path":"/blocked_by","value":[]}]`)
+	if code != http.StatusOK || task.BlockedBy != nil {
+		t.Fatalf("remove last item: %d %+v", code, task)
+	}
+
+	// замена всего документа: неизменяемые поля (в том числе пустые) должны совпасть
+	var doc map[string]any
+	s.ok("", http.MethodGet, "/task/"+a.ID, nil, &doc)
+	doc["title"] = "a2"
+	doc["completed_at"], doc["deleted_at"] = nil, nil
+	whole, _ := json.Marshal(doc)
+	task, code = jsonPatchReq(a.ID, `[{"op":"replace","path":"","value":`+string(whole)+`}]`)
+	if code != http.StatusOK || task.Title != "a2" {
+		t.Fatalf("replace root: %d %+v", code, task)
+	}
+	if _, code = jsonPatchReq(a.ID, `[{"op":"replace","path":"","value":{"title":"x"}}]`); code != http.StatusBadRequest {
+		t.Fatalf("replace root without id: %d", code)
+	}
+
+	// merge patch: null удаляет поле
+	var merged Task
+	s.ok("", http.MethodPatch, "/task/"+a.ID, map[string]any{"shared_with": nil, "description": "d"}, &merged)
+	if merged.SharedWith != nil || merged.Description != "d" {
+		t.Fatalf("merge patch: %+v", merged)
+	}
+}
+
+// applyTestPatch применяет JSON Patch к документу и возвращает результат в JSON
+func applyTestPatch(t *testing.T, doc, patch string) string {
+	t.Helper()
+	v, err := decodeJSON([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = jsonPatch(v, []byte(patch))
+	if err != nil {
+		t.Fatalf("%s: %v", patch, err)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// canonicalJSON - JSON с ключами по алфавиту, как у json.Marshal
+func canonicalJSON(t *testing.T, s string) string {
+	t.Helper()
+	v, err := decodeJSON([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Проекты: у каждого проекта свои задачи - отдельное хранилище
+// (projects/<id>/tasks.json + tasks.wal), история и поисковый индекс,
+// поэтому пагинация, фильтры и поиск в большом проекте не зависят от
+// остальных. Проект "default" - прежний общий список (tasks.json рядом
+// с сервером): старые маршруты (/all, /tasks, /task/:id, ...) работают с ним,
+// а те же маршруты под /projects/:pid/ - с проектом pid (см. taskRoutes).
+//
+//	GET    /projects                      - проекты, которые видит автор запроса
+//	POST   /projects                      - новый проект (автор - владелец)
+//	GET    /projects/:pid
+//	PUT    /projects/:pid                 - имя, настройки, участники
+//	DELETE /projects/:pid                 - только пустой проект (и корзина тоже)
+//	POST   /projects/:pid/tasks/:id/move  - {"project": "..."}: перенос задачи
+//
+// Проект видят владелец, участники (members: имена и "group:..." с ролями)
+// и администраторы, проект default - все. Что можно делать в проекте,
+// определяет роль (см. rbac.go); меняют и удаляют проект владелец
+// и участники с ролью admin. Внутри проекта задачи видны по правилам
+// access.go. Список проектов хранится в projects.json.
+
+// defaultProject - ID проекта с прежним общим списком задач
+const defaultProject = "default"
+
+var errProjectNotFound = errors.New("project not found")
+var errProjectExists = errors.New("project already exists")
+var errProjectNotEmpty = errors.New("project is not empty")
+var errDefaultProject = errors.New("default project cannot be deleted")
+var errBadProjectID = errors.New("project id must be 1-63 lowercase letters, digits and dashes")
+
+// projectIDPattern - ID проекта, заданный клиентом (это еще и имя каталога)
+var projectIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Project - проект
+type Project struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name" binding:"required"`
+	Owner     string          `json:"owner"`
+	Members   []ProjectMember `json:"members,omitempty" binding:"dive"`
+	Settings  ProjectSettings `json:"settings"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ProjectMember - участник проекта: пользователь или "group:имя"
+type ProjectMember struct {
+	Name string `json:"name" binding:"required"`
+	Role Role   `json:"role,omitempty" binding:"omitempty,oneof=viewer editor admin"` // "" - editor
+}
+
+// ProjectSettings - настройки проекта
+type ProjectSettings struct {
+	TasksPerPage    int `json:"tasks_per_page,omitempty" binding:"omitempty,min=1"`     // размер страницы GET /tasks (0 - tasks.per_page)
+	MaxTasks        int `json:"max_tasks,omitempty" binding:"omitempty,min=1"`          // квота задач в проекте (0 - нет, см. quota.go)
+	MaxTasksPerUser int `json:"max_tasks_per_user,omitempty" binding:"omitempty,min=1"` // квота задач одного владельца (0 - нет)
+}
+
+// settings - настройки проекта h
+func (h *handlers) settings() ProjectSettings {
+	if s := h.projectSettings.Load(); s != nil {
+		return *s
+	}
+	return ProjectSettings{}
+}
+
+// setSettings меняет настройки проекта h
+func (h *handlers) setSettings(s ProjectSettings) {
+	h.projectSettings.Store(&s)
+}
+
+// canSeeProject - видит ли p проект
+func (p Principal) canSeeProject(pr Project) bool {
+	return p.projectRole(pr) != roleNone
+}
+
+// project - проект и обработчики его задач
+type project struct {
+	Project
+	h     *handlers
+	close func() error // закрывает файлы хранилища (nil - в памяти)
+	refs  *projectRefs
+}
+
+// projectRefs считает запросы (и фоновые задачи), которые работают
+// с хранилищем проекта: хранилище удаленного проекта закрывается, когда
+// закончится последний из них
+type projectRefs struct {
+	mu      sync.Mutex
+	n       int
+	retired func() // закрыть хранилище (задается при удалении проекта)
+}
+
+// acquire отмечает, что хранилище проекта используется
+func (r *projectRefs) acquire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+}
+
+// release отмечает, что хранилище больше не используется,
+// и закрывает его, если проект уже удален
+func (r *projectRefs) release() {
+	r.mu.Lock()
+	r.n--
+	var retired func()
+	if r.n == 0 {
+		retired, r.retired = r.retired, nil
+	}
+	r.mu.Unlock()
+	if retired != nil {
+		retired()
+	}
+}
+
+// retire закрывает хранилище функцией fn сейчас или после последнего release
+func (r *projectRefs) retire(fn func()) {
+	r.mu.Lock()
+	if r.n > 0 {
+		r.retired = fn
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	fn()
+}
+
+// projectSet - проекты сервера
+type projectSet struct {
+	mu       sync.RWMutex
+	projects map[string]*project
+	root     *handlers // проект default
+	path     string    // projects.json ("" - только в памяти)
+	dir      string    // каталог хранилищ проектов ("" - задачи в памяти)
+}
+
+// defaultProjectSet - проекты сервера, пока это только проект default
+// с обработчиками root; новые проекты хранятся в памяти
+func defaultProjectSet(root *handlers) *projectSet {
+	s := &projectSet{projects: make(map[string]*project), root: root}
+	root.projects = s
+	s.projects[defaultProject] = &project{Project: Project{ID: defaultProject, Name: "Default"}, h: root, refs: &projectRefs{}}
+	return s
+}
+
+// newProjectSet загружает список проектов из файла path и открывает их
+// хранилища в каталоге dir ("" - все только в памяти). root - обработчики
+// проекта default; проекты получают его вебхуки и аутентификацию.
+func newProjectSet(path, dir string, root *handlers) (*projectSet, error) {
+	s := defaultProjectSet(root)
+	s.path, s.dir = path, dir
+	if path == "" {
+		return s, nil
+	}
+
+	var list []Project
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &list)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, pr := range list {
+		if pr.ID == defaultProject {
+			s.projects[defaultProject].Project = pr
+			root.setSettings(pr.Settings)
+			continue
+		}
+		p, err := s.open(pr)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("project %s: %w", pr.ID, err)
+		}
+		s.projects[pr.ID] = p
+	}
+	return s, nil
+}
+
+// open открывает хранилище и историю проекта pr
+func (s *projectSet) open(pr Project) (*project, error) {
+	var store TaskStore = newMemoryStore(nil)
+	var events *eventLog
+	var closers []io.Closer
+	if s.dir != "" {
+		dir := filepath.Join(s.dir, pr.ID)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		wal, report, err := newWALStore(filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal"))
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("project %s: load tasks: %s", pr.ID, report)
+		events, err = newEventLog(filepath.Join(dir, "history.jsonl"))
+		if err != nil {
+			wal.Close()
+			return nil, err
+		}
+		store = wal
+		closers = append(closers, wal, events)
+	}
+
+	h := newHandlers(store, events, s.root.hooks, s.root.auth)
+	h.projects, h.project = s, pr.ID
+	h.events.project = pr.ID
+	h.setSettings(pr.Settings)
+	p := &project{Project: pr, h: h, refs: &projectRefs{}}
+	if len(closers) > 0 {
+		p.close = func() error {
+			var errs []error
+			for _, c := range closers {
+				errs = append(errs, c.Close())
+			}
+			return errors.Join(errs...)
+		}
+	}
+	return p, nil
+}
+
+// save записывает список проектов в файл (вызывается под s.mu);
+// ошибка записи оборачивается в errNotSaved
+func (s *projectSet) save() error {
+	if s.path == "" {
+		return nil
+	}
+	list := make([]Project, 0, len(s.projects))
+	for _, p := range s.projects {
+		list = append(list, p.Project)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	err := writeFileAtomic(s.path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(list)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotSaved, err)
+	}
+	return nil
+}
+
+// get - проект id (копия), если его видит p
+func (s *projectSet) get(p Principal, id string) (project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pr, ok := s.projects[id]
+	if !ok || !p.canSeeProject(pr.Project) {
+		return project{}, errProjectNotFound
+	}
+	return *pr, nil
+}
+
+// acquire - то же, что get, для работы с задачами проекта: хранилище
+// не закроется (даже если проект удалят), пока не вызвана release
+func (s *projectSet) acquire(p Principal, id string) (project, func(), error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.projects[id]
+	if !ok || !p.canSeeProject(found.Project) {
+		return project{}, nil, errProjectNotFound
+	}
+	found.refs.acquire()
+	return *found, found.refs.release, nil
+}
+
+// list - проекты, которые видит p (по ID)
+func (s *projectSet) list(p Principal) []Project {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Project{}
+	for _, pr := range s.projects {
+		if p.canSeeProject(pr.Project) {
+			list = append(list, pr.Project)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// all - обработчики всех проектов (для фоновых задач, например корзины);
+// их хранилища не закроются, пока не вызвана release
+func (s *projectSet) all() (list []*handlers, release func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refs := make([]*projectRefs, 0, len(s.projects))
+	for _, pr := range s.projects {
+		pr.refs.acquire()
+		list = append(list, pr.h)
+		refs = append(refs, pr.refs)
+	}
+	return list, func() {
+		for _, r := range refs {
+			r.release()
+		}
+	}
+}
+
+// create добавляет проект pr от имени p
+func (s *projectSet) create(p Principal, pr Project) (Project, error) {
+	switch {
+	case pr.ID == "":
+		pr.ID = uuid.NewString()
+	case !projectIDPattern.MatchString(pr.ID):
+		return Project{}, errBadProjectID
+	}
+	pr.Owner = p.Name
+	pr.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[pr.ID]; ok {
+		return Project{}, errProjectExists
+	}
+	opened, err := s.open(pr)
+	if err != nil {
+		return Project{}, err
+	}
+	s.projects[pr.ID] = opened
+	return pr, s.save()
+}
+
+// update меняет имя, участников и настройки проекта pr.ID от имени p
+// (роль p проверена по policies)
+func (s *projectSet) update(p Principal, pr Project) (Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.projects[pr.ID]
+	if !ok || !p.canSeeProject(current.Project) {
+		return Project{}, errProjectNotFound
+	}
+	pr.Owner, pr.CreatedAt = current.Owner, current.CreatedAt
+	current.Project = pr
+	current.h.setSettings(pr.Settings)
+	return pr, s.save()
+}
+
+// remove удаляет пустой проект id от имени p вместе с его файлами
+// (роль p проверена по policies). Запросы, которые уже работают с проектом,
+// доделываются: хранилище закрывается после них.
+func (s *projectSet) remove(p Principal, id string) error {
+	if id == defaultProject {
+		return errDefaultProject
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pr, ok := s.projects[id]
+	if !ok || !p.canSeeProject(pr.Project) {
+		return errProjectNotFound
+	}
+	pr.h.mu.Lock()
+	defer pr.h.mu.Unlock()
+	if pr.h.store.Count() > 0 || len(pr.h.store.Trash()) > 0 {
+		return errProjectNotEmpty
+	}
+	delete(s.projects, id)
+	if pr.close != nil {
+		pr.refs.retire(func() {
+			err := pr.close()
+			if err != nil {
+				log.Printf("project %s: close: %v", id, err)
+			}
+			err = os.RemoveAll(filepath.Join(s.dir, id))
+			if err != nil {
+				log.Printf("project %s: remove: %v", id, err)
+			}
+		})
+	}
+	return s.save()
+}
+
+// Close закрывает хранилища проектов (кроме default)
+func (s *projectSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, pr := range s.projects {
+		if pr.close != nil {
+			errs = append(errs, pr.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// inProject связывает обработчик задач с проектом запроса
+// (:pid или default, его находит authorize)
+func inProject(fn func(*handlers, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fn(projectFrom(c).h, c)
+	}
+}
+
+// projectError отправляет ответ на ошибку операции с проектом
+func projectError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errProjectNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errProjectExists), errors.Is(err, errProjectNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errDefaultProject), errors.Is(err, errBadProjectID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		storeError(c, err)
+	}
+}
+
+// обработчик запроса GET /projects
+func (h *handlers) listProjects(c *gin.Context) {
+	c.JSON(http.StatusOK, h.projects.list(principalFrom(c)))
+}
+
+// обработчик запроса GET /projects/:pid
+func (h *handlers) getProject(c *gin.Context) {
+	pr, err := h.projects.get(principalFrom(c), c.Param("pid"))
+	if err != nil {
+		projectError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project": pr.Project, "tasks": pr.h.store.Count()})
+}
+
+// обработчик запроса POST /projects
+func (h *handlers) createProject(c *gin.Context) {
+	var pr Project
+	err := c.BindJSON(&pr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pr, err = h.projects.create(principalFrom(c), pr)
+	h.projectSaved(c, pr, err)
+}
+
+// обработчик запроса PUT /projects/:pid
+func (h *handlers) updateProject(c *gin.Context) {
+	var pr Project
+	err := c.BindJSON(&pr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pr.ID = c.Param("pid")
+	pr, err = h.projects.update(principalFrom(c), pr)
+	h.projectSaved(c, pr, err)
+}
+
+// projectSaved отвечает на создание или изменение проекта
+func (h *handlers) projectSaved(c *gin.Context, pr Project, err error) {
+	if err != nil && !errors.Is(err, errNotSaved) {
+		projectError(c, err)
+		return
+	}
+	// проект изменен, но список проектов не записан
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"project": pr, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pr)
+}
+
+// обработчик запроса DELETE /projects/:pid
+func (h *handlers) deleteProject(c *gin.Context) {
+	err := h.projects.remove(principalFrom(c), c.Param("pid"))
+	if err != nil && !errors.Is(err, errNotSaved) {
+		projectError(c, err)
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"message": "project deleted", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "project deleted"})
+}
+
+// обработчик запроса POST /tasks/:id/move (и /projects/:pid/tasks/:id/move) -
+// перенос задачи в проект {"project": "..."}. В новом проекте задача
+// создается заново с тем же ID (seq, version и created_at - новые), из
+// старого удаляется навсегда. Задачи с зависимостями не переносятся:
+// blocked_by ссылается только на задачи своего проекта.
+func (h *handlers) moveTask(c *gin.Context) {
+	var req struct {
+		Project string `json:"project" binding:"required"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p := principalFrom(c)
+	target, release, err := h.projects.acquire(p, req.Project)
+	if err != nil {
+		projectError(c, err)
+		return
+	}
+	defer release()
+	if role := p.projectRole(target.Project); !role.atLeast(roleEditor) {
+		forbidden(c, reasonRole, errRole, gin.H{"role": role, "required_role": roleEditor, "project": target.ID})
+		return
+	}
+	logAttrs(c, "task_id", c.Param("id"), "target_project", target.ID)
+	dst := target.h
+	if dst == h {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task is already in this project"})
+		return
+	}
+
+	// два проекта блокируются всегда в одном порядке (по ID), чтобы
+	// встречные переносы не ждали друг друга вечно
+	first, second := h, dst
+	if first.project > second.project {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	task, err := h.visibleTask(p, c.Param("id"))
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	if _, ok := ifMatch(c, task); !ok {
+		return
+	}
+	if !p.canShare(task) {
+		accessError(c, errNotOwner)
+		return
+	}
+	dependent := slices.ContainsFunc(h.store.List(), func(t Task) bool { return slices.Contains(t.BlockedBy, task.ID) })
+	if len(task.BlockedBy) > 0 || dependent {
+		c.JSON(http.StatusConflict, gin.H{"error": "tasks with dependencies cannot be moved"})
+		return
+	}
+
+	err = dst.taskQuota().add(task.Owner)
+	if err != nil {
+		quotaExceeded(c, err, nil)
+		return
+	}
+	moved := task
+	moved.UpdatedBy = p.Name
+	done, err := dst.store.Batch([]change{{Op: opCreate, Task: moved}})
+	if err != nil && !errors.Is(err, errNotSaved) {
+		var berr *batchError
+		if errors.As(err, &berr) {
+			err = berr.Err
+		}
+		storeError(c, err)
+		return
+	}
+	moved = done[0]
+	err = h.store.Delete(Task{ID: task.ID, Version: task.Version, UpdatedBy: p.Name})
+	if err == nil || errors.Is(err, errNotSaved) {
+		err = h.store.Purge(Task{ID: task.ID, UpdatedBy: p.Name})
+	}
+	if err != nil && !errors.Is(err, errNotSaved) {
+		// задача осталась в старом проекте - убираем копию из нового
+		_ = dst.store.Delete(Task{ID: moved.ID, UpdatedBy: p.Name})
+		_ = dst.store.Purge(Task{ID: moved.ID, UpdatedBy: p.Name})
+		storeError(c, err)
+		return
+	}
+	c.Header("ETag", taskETag(moved))
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"task": moved, "project": target.ID, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": moved, "project": target.ID})
+}
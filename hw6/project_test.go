@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjects(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*testServer, *projectSet) {
+		s := newTestServer(t, nil, apiKey{Name: "alice"}, apiKey{Name: "bob"})
+		projects, err := newProjectSet(filepath.Join(dir, "projects.json"), filepath.Join(dir, "projects"), s.h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, projects
+	}
+	s, projects := open()
+	do := s.do
+
+	w := do("alice", http.MethodPost, "/projects", Project{ID: "work", Name: "Work", Settings: ProjectSettings{TasksPerPage: 2}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create project: %d %s", w.Code, w.Body)
+	}
+	if w := do("alice", http.MethodPost, "/projects", Project{ID: "Bad/ID", Name: "x"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad project id: %d", w.Code)
+	}
+	for _, title := range []string{"a", "b", "c"} {
+		if w := do("alice", http.MethodPost, "/projects/work/tasks", Task{Title: title}); w.Code != http.StatusOK {
+			t.Fatalf("create task: %d %s", w.Code, w.Body)
+		}
+	}
+	do("alice", http.MethodPost, "/task", Task{Title: "default"})
+
+	// у проекта своя пагинация (размер страницы из настроек) и свой список
+	var page taskPage
+	_ = json.Unmarshal(do("alice", http.MethodGet, "/projects/work/tasks", nil).Body.Bytes(), &page)
+	if page.Total != 3 || len(page.Items) != 2 {
+		t.Fatalf("work page = %+v", page)
+	}
+	if tasks := decodeTasks(t, do("alice", http.MethodGet, "/all", nil)); len(tasks) != 1 {
+		t.Fatalf("default project = %+v", tasks)
+	}
+
+	// чужой проект не виден, пока bob не участник
+	if w := do("bob", http.MethodGet, "/projects/work/all", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("non-member: %d", w.Code)
+	}
+	members := Project{Name: "Work", Members: []ProjectMember{{Name: "bob"}}, Settings: ProjectSettings{TasksPerPage: 2}}
+	if w := do("bob", http.MethodPut, "/projects/work", members); w.Code != http.StatusNotFound {
+		t.Fatalf("update by non-member: %d", w.Code)
+	}
+	if w := do("alice", http.MethodPut, "/projects/work", members); w.Code != http.StatusOK {
+		t.Fatalf("add member: %d %s", w.Code, w.Body)
+	}
+	if w := do("bob", http.MethodGet, "/projects/work", nil); w.Code != http.StatusOK {
+		t.Fatalf("member: %d %s", w.Code, w.Body)
+	}
+
+	// перенос задачи из default в work
+	moved := decodeTasks(t, do("alice", http.MethodGet, "/all", nil))[0]
+	w = do("alice", http.MethodPost, "/tasks/"+moved.ID+"/move", map[string]string{"project": "work"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("move: %d %s", w.Code, w.Body)
+	}
+	if w := do("alice", http.MethodGet, "/projects/work/task/"+moved.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("moved task: %d %s", w.Code, w.Body)
+	}
+	if w := do("alice", http.MethodGet, "/task/"+moved.ID, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("task left in default: %d", w.Code)
+	}
+	var history struct{ Events []Event }
+	_ = json.Unmarshal(do("alice", http.MethodGet, "/projects/work/task/"+moved.ID+"/history", nil).Body.Bytes(), &history)
+	if len(history.Events) != 1 || history.Events[0].Project != "work" {
+		t.Fatalf("history = %+v", history)
+	}
+
+	// удалить можно только пустой проект; default удаляет только admin (и тоже нельзя)
+	if w := do("alice", http.MethodDelete, "/projects/work", nil); w.Code != http.StatusConflict {
+		t.Fatalf("delete non-empty: %d", w.Code)
+	}
+	if w := do("alice", http.MethodDelete, "/projects/default", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("delete default: %d", w.Code)
+	}
+
+	// проекты и их задачи переживают перезапуск
+	if err := projects.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, projects = open()
+	defer projects.Close()
+	work, err := projects.get(Principal{Name: "bob"}, "work")
+	if err != nil || work.h.store.Count() != 4 || work.Settings.TasksPerPage != 2 {
+		t.Fatalf("reloaded work = %+v, %v", work.Project, err)
+	}
+}
+
+func TestProjectRemoveInFlight(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, nil, apiKey{Name: "alice"})
+	projects, err := newProjectSet(filepath.Join(dir, "projects.json"), filepath.Join(dir, "projects"), s.h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer projects.Close()
+	s.ok("alice", http.MethodPost, "/projects", Project{ID: "tmp", Name: "Tmp"}, nil)
+
+	// запрос к проекту еще идет, когда проект удаляют
+	pr, release, err := projects.acquire(Principal{Name: "alice"}, "tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ok("alice", http.MethodDelete, "/projects/tmp", nil, nil)
+	if w := s.do("alice", http.MethodGet, "/projects/tmp/all", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("deleted project: %d", w.Code)
+	}
+	// его хранилище открыто до конца запроса
+	if _, err := pr.h.store.Create(Task{Title: "late"}); err != nil {
+		t.Fatalf("store closed under a request: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "projects", "tmp")); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := os.Stat(filepath.Join(dir, "projects", "tmp")); !os.IsNotExist(err) {
+		t.Fatalf("project files after release: %v", err)
+	}
+	if _, err := pr.h.store.Create(Task{Title: "after"}); err == nil {
+		t.Fatal("store still open after release")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Квоты на число задач в проекте: настройки проекта max_tasks (всего
+// задач) и max_tasks_per_user (задач одного владельца), 0 - без
+// ограничения. Задачи в корзине не считаются. Квоты проверяются везде, где
+// появляются новые задачи: POST /task, create в POST /tasks/batch,
+// POST /import и перенос задачи в проект. Превышение - 403 с reason
+// "quota_exceeded": повтор запроса не поможет, в отличие от 429 (rate.go).
+
+var errQuota = errors.New("task quota exceeded")
+
+// reasonQuota - причина отказа при превышении квоты (см. forbidden)
+const reasonQuota = "quota_exceeded"
+
+// quotaError - какая квота превышена
+type quotaError struct {
+	Quota string // "project" или "user"
+	Limit int
+}
+
+func (e *quotaError) Error() string {
+	if e.Quota == "user" {
+		return fmt.Sprintf("%v: at most %d tasks per user in this project", errQuota, e.Limit)
+	}
+	return fmt.Sprintf("%v: at most %d tasks in this project", errQuota, e.Limit)
+}
+
+func (e *quotaError) Unwrap() error { return errQuota }
+
+// taskQuota считает задачи, которые создаются одним запросом
+// (вызывается под h.mu)
+type taskQuota struct {
+	h        *handlers
+	settings ProjectSettings
+	total    int
+	owned    map[string]int // задач у владельца (считаются при первом обращении)
+}
+
+// taskQuota - счетчик квот проекта h
+func (h *handlers) taskQuota() *taskQuota {
+	return &taskQuota{h: h, settings: h.settings(), total: h.store.Count(), owned: make(map[string]int)}
+}
+
+// add учитывает новую задачу владельца owner; если она не помещается
+// в квоту, возвращает *quotaError и ничего не учитывает
+func (q *taskQuota) add(owner string) error {
+	if max := q.settings.MaxTasks; max > 0 && q.total >= max {
+		return &quotaError{Quota: "project", Limit: max}
+	}
+	max := q.settings.MaxTasksPerUser
+	if max > 0 && owner != "" {
+		n, ok := q.owned[owner]
+		if !ok {
+			for _, task := range q.h.store.Visible([]string{userPrefix + owner}) {
+				if task.Owner == owner {
+					n++
+				}
+			}
+		}
+		if n >= max {
+			return &quotaError{Quota: "user", Limit: max}
+		}
+		q.owned[owner] = n + 1
+	}
+	q.total++
+	return nil
+}
+
+// quotaExceeded отправляет ответ на превышение квоты; details - другие
+// поля ответа (nil - нет)
+func quotaExceeded(c *gin.Context, err error, details gin.H) {
+	var qerr *quotaError
+	errors.As(err, &qerr)
+	body := gin.H{"quota": qerr.Quota, "limit": qerr.Limit}
+	maps.Copy(body, details)
+	forbidden(c, reasonQuota, err, body)
+}
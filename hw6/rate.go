@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничение частоты запросов (token bucket).
+//
+// У каждого клиента - по "ведру" на класс маршрутов: чтение (GET, HEAD)
+// и запись (остальные методы). В ведре до Burst жетонов, каждую секунду
+// добавляется Rate жетонов; запрос забирает один. Пустое ведро -
+// 429 Too Many Requests с Retry-After. Клиент - пользователь, если запрос
+// аутентифицирован (ключ или токен), иначе IP-адрес (X-Forwarded-For
+// учитывается только от прокси из -trusted-proxies).
+//
+// Ответы несут заголовки (draft-ietf-httpapi-ratelimit-headers):
+//
+//	RateLimit-Limit     - размер ведра
+//	RateLimit-Remaining - сколько жетонов осталось
+//	RateLimit-Reset     - через сколько секунд ведро снова будет полным
+
+// rateClass - ограничение для класса маршрутов (Rate == 0 - без ограничения)
+type rateClass struct {
+	Rate  float64 // жетонов в секунду
+	Burst int     // размер ведра
+}
+
+// классы маршрутов
+const (
+	rateRead  = "read"
+	rateWrite = "write"
+)
+
+// rateSweepEvery - как часто забывать полные ведра (клиентов, которые
+// давно не приходили)
+const rateSweepEvery = time.Minute
+
+// tokenBucket - ведро клиента
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // когда tokens посчитаны
+}
+
+// bucketKey - класс маршрутов и клиент
+type bucketKey struct{ class, client string }
+
+// rateLimiter - ведра всех клиентов
+type rateLimiter struct {
+	mu      sync.Mutex
+	classes map[string]rateClass
+	buckets map[bucketKey]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// newRateLimiter создает ограничитель с ограничениями read и write
+func newRateLimiter(read, write rateClass) *rateLimiter {
+	return &rateLimiter{
+		classes: map[string]rateClass{rateRead: read, rateWrite: write},
+		buckets: make(map[bucketKey]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// rateState - состояние ведра после запроса
+type rateState struct {
+	Unlimited  bool // класс без ограничения: остальные поля пусты
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // до полного ведра
+	RetryAfter time.Duration // до следующего жетона (если запрос отклонен)
+}
+
+// take забирает жетон из ведра клиента client в классе class;
+// false - жетонов нет. Ограничение класса читается под тем же l.mu,
+// что и ведро: setClasses между проверкой и делением на Rate невозможен
+func (l *rateLimiter) take(class, client string) (rateState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rc := l.classes[class]
+	if rc.Rate <= 0 {
+		return rateState{Unlimited: true}, true
+	}
+
+	now := l.now()
+	if now.Sub(l.swept) >= rateSweepEvery {
+		l.sweep(now)
+	}
+	key := bucketKey{class, client}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rc.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rc.Burst), b.tokens+now.Sub(b.last).Seconds()*rc.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	state := rateState{
+		Limit:     rc.Burst,
+		Remaining: int(b.tokens),
+		Reset:     seconds((float64(rc.Burst) - b.tokens) / rc.Rate),
+	}
+	if !allowed {
+		state.RetryAfter = seconds((1 - b.tokens) / rc.Rate)
+	}
+	return state, allowed
+}
+
+// setClasses меняет ограничения (по SIGHUP, см. config.go); ведра
+// клиентов остаются, лишние жетоны отрезаются при следующем запросе
+func (l *rateLimiter) setClasses(read, write rateClass) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.classes = map[string]rateClass{rateRead: read, rateWrite: write}
+}
+
+// sweep забывает полные ведра (вызывается под l.mu)
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		rc := l.classes[key.class]
+		if b.tokens+now.Sub(b.last).Seconds()*rc.Rate >= float64(rc.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// seconds переводит секунды в time.Duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// rateLimit - middleware, ограничивающая частоту запросов клиента
+// (nil - без ограничений)
+func (l *rateLimiter) rateLimit(c *gin.Context) {
+	if l == nil {
+		return
+	}
+	class := rateWrite
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		class = rateRead
+	}
+	client := "ip:" + c.ClientIP()
+	if p := principalFrom(c); p.Method == authAPIKey || p.Method == authToken {
+		client = userPrefix + p.Name
+	}
+
+	state, ok := l.take(class, client)
+	if state.Unlimited {
+		return
+	}
+	c.Header("RateLimit-Limit", strconv.Itoa(state.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(state.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(state.Reset)))
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(state.RetryAfter)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+	}
+}
+
+// ceilSeconds - d в целых секундах с округлением вверх
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	s := newTestServer(t, nil, apiKey{Name: "script"}, apiKey{Name: "alice"})
+	s.h.limits = newRateLimiter(rateClass{Rate: 10, Burst: 5}, rateClass{Rate: 0.5, Burst: 2})
+	clock := time.Now()
+	s.h.limits.now = func() time.Time { return clock }
+	s.rebuild()
+	do := func(user, method, url string) *httptest.ResponseRecorder {
+		return s.do(user, method, url, Task{Title: "loop"})
+	}
+
+	// скрипт в цикле создает задачи: после двух - 429
+	for i, remaining := range []string{"1", "0"} {
+		w := do("script", http.MethodPost, "/task")
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != remaining {
+			t.Fatalf("write %d: %d %v", i, w.Code, w.Header())
+		}
+	}
+	w := do("script", http.MethodPost, "/task")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" || w.Header().Get("RateLimit-Reset") != "4" {
+		t.Fatalf("over the limit: %d %v", w.Code, w.Header())
+	}
+
+	// чтение - отдельное ведро, другие клиенты - тоже
+	if w := do("script", http.MethodGet, "/all"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "5" {
+		t.Fatalf("read: %d %v", w.Code, w.Header())
+	}
+	if w := do("alice", http.MethodPost, "/task"); w.Code != http.StatusOK {
+		t.Fatalf("another client: %d", w.Code)
+	}
+
+	// через Retry-After жетон появляется снова
+	clock = clock.Add(2 * time.Second)
+	if w := do("script", http.MethodPost, "/task"); w.Code != http.StatusOK {
+		t.Fatalf("after Retry-After: %d %v", w.Code, w.Header())
+	}
+
+	// вход по паролю ограничен по IP
+	for i := 0; i < 2; i++ {
+		do("", http.MethodPost, "/auth/token")
+	}
+	if w := do("", http.MethodPost, "/auth/token"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login attempts: %d", w.Code)
+	}
+
+	// полные ведра забываются
+	clock = clock.Add(rateSweepEvery)
+	do("alice", http.MethodGet, "/all")
+	if n := len(s.h.limits.buckets); n != 1 {
+		t.Fatalf("%d buckets after sweep", n)
+	}
+
+	// ограничение снято по SIGHUP: ни 429, ни заголовков
+	s.h.limits.setClasses(rateClass{}, rateClass{})
+	for i := 0; i < 3; i++ {
+		if w := do("script", http.MethodPost, "/task"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("unlimited %d: %d %v", i, w.Code, w.Header())
+		}
+	}
+}
+
+func TestRateLimitProxies(t *testing.T) {
+	defer config.Store(currentConfig())
+	s := newTestServer(t, nil, apiKey{Name: "alice"})
+	s.h.limits = newRateLimiter(rateClass{}, rateClass{Rate: 0.5, Burst: 2})
+	// каждая попытка входа - с новым X-Forwarded-For (httptest: RemoteAddr 192.0.2.1)
+	login := func(i int) int {
+		ip := "203.0.113." + strconv.Itoa(i)
+		return s.do("", http.MethodPost, "/auth/token", nil, "X-Forwarded-For", ip, "X-Real-IP", ip).Code
+	}
+
+	// по умолчанию прокси нет: заголовок не меняет клиента
+	s.rebuild()
+	for i := 0; i < 2; i++ {
+		login(i)
+	}
+	if code := login(2); code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed X-Forwarded-For: %d", code)
+	}
+
+	// за доверенным прокси клиент - адрес из X-Forwarded-For
+	cfg := *currentConfig()
+	cfg.TrustedProxies = "10.0.0.0/8, 192.0.2.1"
+	config.Store(&cfg)
+	s.rebuild()
+	for i := 10; i < 15; i++ {
+		if code := login(i); code == http.StatusTooManyRequests {
+			t.Fatalf("behind a trusted proxy, attempt %d: %d", i, code)
+		}
+	}
+
+	cfg.TrustedProxies = "10.0.0.0/33"
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "trusted_proxies") {
+		t.Fatalf("validate: %v", err)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	s := newTestServer(t, nil, apiKey{Name: "alice"}, apiKey{Name: "bob"}, apiKey{Name: "root", Admin: true})
+	do := s.do
+	quotaError := func(w *httptest.ResponseRecorder) string {
+		var resp struct{ Reason, Quota string }
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusForbidden || resp.Reason != reasonQuota {
+			t.Fatalf("want quota error, got %d %s", w.Code, w.Body)
+		}
+		return resp.Quota
+	}
+
+	settings := ProjectSettings{MaxTasks: 3, MaxTasksPerUser: 2}
+	if w := do("root", http.MethodPut, "/projects/default", Project{Name: "Default", Settings: settings}); w.Code != http.StatusOK {
+		t.Fatalf("settings: %d %s", w.Code, w.Body)
+	}
+	do("alice", http.MethodPost, "/task", Task{Title: "a1"})
+	do("alice", http.MethodPost, "/task", Task{Title: "a2"})
+	if q := quotaError(do("alice", http.MethodPost, "/task", Task{Title: "a3"})); q != "user" {
+		t.Fatalf("quota = %q", q)
+	}
+
+	// пакет тоже не обходит квоту - и не выполняется целиком
+	batch := map[string]any{"operations": []map[string]any{
+		{"op": "create", "task": Task{Title: "b1"}},
+		{"op": "create", "task": Task{Title: "b2"}},
+	}}
+	w := do("bob", http.MethodPost, "/tasks/batch", batch)
+	var resp struct{ Results []batchResult }
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusForbidden || len(resp.Results) != 2 || !strings.HasPrefix(resp.Results[1].Error, errQuota.Error()) {
+		t.Fatalf("batch over the project quota: %d %s", w.Code, w.Body)
+	}
+	if w := do("bob", http.MethodPost, "/task", Task{Title: "b1"}); w.Code != http.StatusOK {
+		t.Fatalf("within quota: %d %s", w.Code, w.Body)
+	}
+
+	// задачи в корзине не считаются
+	tasks := decodeTasks(t, do("alice", http.MethodGet, "/all", nil))
+	do("alice", http.MethodDelete, "/tasks/"+tasks[0].ID, nil)
+	if w := do("alice", http.MethodPost, "/task", Task{Title: "a3"}); w.Code != http.StatusOK {
+		t.Fatalf("after delete: %d %s", w.Code, w.Body)
+	}
+
+	// загрузка - тот же ответ, что у POST /task, и ошибки строк
+	w, _ = s.importFile("bob", "format=ndjson", `{"title": "i1"}`)
+	if q := quotaError(w); q != "project" || !strings.Contains(w.Body.String(), `"errors":[{"row":1`) {
+		t.Fatalf("import over the project quota: %s", w.Body)
+	}
+}
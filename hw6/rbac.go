@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Роли в проектах.
+//
+// У пользователя в каждом проекте одна из ролей (по возрастанию прав):
+//
+//	viewer - читает задачи, историю, события, поиск, экспорт и корзину;
+//	editor - вдобавок создает, меняет, удаляет в корзину и переносит задачи;
+//	admin  - вдобавок удаляет задачи навсегда, меняет и удаляет проект.
+//
+// Роль в проекте: администраторы сервера (Principal.Admin) и владелец
+// проекта - admin; участники - роль из members (по умолчанию editor; если
+// подходят несколько записей, например имя и группа, - старшая). В проекте
+// default остальные пользователи - editor, в других проектах их нет (404).
+//
+// Какая роль нужна для маршрута, записано в одной таблице policies;
+// проверяет ее middleware authorize. Маршрута без записи в таблице нет ни
+// для кого (403), поэтому новый маршрут без политики сразу заметен. Права
+// на отдельные задачи (владелец, shared_with - см. access.go) проверяются
+// поверх роли. Запрет - 403 с полем reason (см. forbidden).
+
+// Role - роль в проекте
+type Role string
+
+const (
+	roleNone   Role = ""
+	roleViewer Role = "viewer"
+	roleEditor Role = "editor"
+	roleAdmin  Role = "admin"
+)
+
+// roleRank - старшинство ролей
+var roleRank = map[Role]int{roleNone: 0, roleViewer: 1, roleEditor: 2, roleAdmin: 3}
+
+// atLeast - не младше ли роль r роли min
+func (r Role) atLeast(min Role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// причины отказа (поле reason ответа 403)
+const (
+	reasonRole     = "insufficient_role" // роль ниже нужной
+	reasonNoPolicy = "no_policy"         // маршрута нет в policies
+	reasonNotOwner = "not_task_owner"    // владельца и shared_with меняет только владелец
+)
+
+var errRole = errors.New("insufficient role")
+var errNoPolicy = errors.New("no access policy for this route")
+
+// policy - кто может вызывать маршрут
+type policy struct {
+	Role   Role // нужная роль
+	Global bool // роль на сервере, а не в проекте: admin - Principal.Admin, остальные - editor
+}
+
+// policies - роли для маршрутов API ("МЕТОД путь"). Маршруты задач
+// записаны без префикса /projects/:pid: они одинаковы в проекте default
+// и под /projects/:pid, роль проверяется в проекте запроса.
+var policies = map[string]policy{
+	"GET /auth/me": {Role: roleViewer, Global: true},
+
+	// чтение
+	"GET /all":              {Role: roleViewer},
+	"GET /tasks":            {Role: roleViewer},
+	"GET /task/:id":         {Role: roleViewer},
+	"GET /tasks/:id":        {Role: roleViewer},
+	"GET /task/:id/graph":   {Role: roleViewer},
+	"GET /task/:id/history": {Role: roleViewer},
+	"GET /events":           {Role: roleViewer},
+	"GET /events/stream":    {Role: roleViewer},
+	"GET /search":           {Role: roleViewer},
+	"GET /export":           {Role: roleViewer},
+	"GET /trash":            {Role: roleViewer},
+
+	// изменение задач
+	"POST /task":              {Role: roleEditor},
+	"POST /tasks":             {Role: roleEditor},
+	"POST /tasks/batch":       {Role: roleEditor},
+	"PUT /task/:id":           {Role: roleEditor},
+	"PUT /tasks/:id":          {Role: roleEditor},
+	"PATCH /task/:id":         {Role: roleEditor},
+	"PATCH /tasks/:id":        {Role: roleEditor},
+	"DELETE /tasks/:id":       {Role: roleEditor},
+	"POST /import":            {Role: roleEditor},
+	"POST /trash/:id/restore": {Role: roleEditor},
+	"POST /tasks/:id/move":    {Role: roleEditor}, // и editor в проекте назначения (moveTask)
+
+	// удаление навсегда
+	"DELETE /trash/:id": {Role: roleAdmin},
+	"DELETE /trash":     {Role: roleAdmin},
+
+	// проекты
+	"GET /projects":         {Role: roleViewer, Global: true},
+	"POST /projects":        {Role: roleEditor, Global: true},
+	"GET /projects/:pid":    {Role: roleViewer},
+	"PUT /projects/:pid":    {Role: roleAdmin},
+	"DELETE /projects/:pid": {Role: roleAdmin},
+
+	// вебхуки получают события всех проектов - только администраторы сервера
+	"POST /webhooks":                    {Role: roleAdmin, Global: true},
+	"GET /webhooks":                     {Role: roleAdmin, Global: true},
+	"GET /webhooks/dead":                {Role: roleAdmin, Global: true},
+	"POST /webhooks/dead/:id/redeliver": {Role: roleAdmin, Global: true},
+	"DELETE /webhooks/dead/:id":         {Role: roleAdmin, Global: true},
+	"GET /webhooks/:id":                 {Role: roleAdmin, Global: true},
+	"PUT /webhooks/:id":                 {Role: roleAdmin, Global: true},
+	"DELETE /webhooks/:id":              {Role: roleAdmin, Global: true},
+	"GET /webhooks/:id/queue":           {Role: roleAdmin, Global: true},
+
+	// метрики - по задачам всех проектов (Prometheus - с ключом администратора)
+	"GET /metrics": {Role: roleAdmin, Global: true},
+}
+
+// projectPrefix - префикс маршрутов задач проекта
+const projectPrefix = "/projects/:pid"
+
+// routePolicy - политика маршрута method path (path - шаблон gin)
+func routePolicy(method, path string) (policy, bool) {
+	pol, ok := policies[method+" "+path]
+	if !ok && strings.HasPrefix(path, projectPrefix+"/") {
+		pol, ok = policies[method+" "+strings.TrimPrefix(path, projectPrefix)]
+	}
+	return pol, ok
+}
+
+// globalRole - роль p на сервере
+func (p Principal) globalRole() Role {
+	if p.Admin {
+		return roleAdmin
+	}
+	return roleEditor
+}
+
+// projectRole - роль p в проекте pr (roleNone - проект p не виден)
+func (p Principal) projectRole(pr Project) Role {
+	if p.Admin || (pr.Owner != "" && pr.Owner == p.Name) {
+		return roleAdmin
+	}
+	role := roleNone
+	mine := p.accessKeys()
+	for _, m := range pr.Members {
+		if !slices.Contains(mine, shareKey(m.Name)) {
+			continue
+		}
+		r := m.Role
+		if r == roleNone {
+			r = roleEditor
+		}
+		if !role.atLeast(r) {
+			role = r
+		}
+	}
+	if role == roleNone && pr.ID == defaultProject {
+		role = roleEditor
+	}
+	return role
+}
+
+// projectKey - ключ проекта запроса в gin.Context
+const projectKey = "project"
+
+// authorize - middleware, которая проверяет роль автора запроса по
+// policies и запоминает проект запроса (:pid или default) для inProject
+func (h *handlers) authorize(c *gin.Context) {
+	pol, ok := routePolicy(c.Request.Method, c.FullPath())
+	if !ok {
+		forbidden(c, reasonNoPolicy, errNoPolicy, nil)
+		return
+	}
+	p := principalFrom(c)
+	role := p.globalRole()
+	if !pol.Global {
+		pid := c.Param("pid")
+		if pid == "" {
+			pid = defaultProject
+		}
+		pr, release, err := h.projects.acquire(p, pid)
+		if err != nil {
+			projectError(c, err)
+			c.Abort()
+			return
+		}
+		// хранилище проекта не закроется, пока запрос не закончится
+		defer release()
+		c.Set(projectKey, pr)
+		logAttrs(c, "project", pr.ID)
+		role = p.projectRole(pr.Project)
+	}
+	if !role.atLeast(pol.Role) {
+		forbidden(c, reasonRole, errRole, gin.H{"role": role, "required_role": pol.Role})
+		return
+	}
+	c.Next()
+}
+
+// projectFrom - проект запроса (после authorize)
+func projectFrom(c *gin.Context) project {
+	v, _ := c.Get(projectKey)
+	pr, _ := v.(project)
+	return pr
+}
+
+// forbidden прерывает запрос ответом 403: error - для людей, reason
+// (и details) - для программ
+func forbidden(c *gin.Context, reason string, err error, details gin.H) {
+	body := gin.H{"error": err.Error(), "reason": reason}
+	maps.Copy(body, details)
+	c.AbortWithStatusJSON(http.StatusForbidden, body)
+}
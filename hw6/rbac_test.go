@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	s := newTestServer(t, nil,
+		apiKey{Name: "viewer"},
+		apiKey{Name: "editor", Groups: []string{"dev"}},
+		apiKey{Name: "admin"},
+		apiKey{Name: "carol"},
+		apiKey{Name: "root", Admin: true},
+	)
+	do := func(user, method, url string, body any) *httptest.ResponseRecorder {
+		req := s.request(user, method, url, body)
+		if strings.HasSuffix(url, "/events/stream") {
+			ctx, cancel := context.WithCancel(req.Context())
+			cancel() // поток событий сразу закрывается
+			req = req.WithContext(ctx)
+		}
+		return s.serve(req)
+	}
+
+	// роли в проекте work и в default одинаковые; в work есть задача,
+	// поэтому удалить его нельзя (409), но запрос до обработчика доходит
+	members := []ProjectMember{{Name: "viewer", Role: roleViewer}, {Name: "group:dev"}, {Name: "admin", Role: roleAdmin}}
+	for _, w := range []*httptest.ResponseRecorder{
+		do("root", http.MethodPost, "/projects", Project{ID: "work", Name: "Work", Members: members}),
+		do("root", http.MethodPut, "/projects/default", Project{Name: "Default", Members: members}),
+		do("root", http.MethodPost, "/projects/work/task", Task{Title: "keep"}),
+	} {
+		if w.Code != http.StatusOK {
+			t.Fatalf("setup: %d %s", w.Code, w.Body)
+		}
+	}
+	if w := do("root", http.MethodPut, "/projects/work", Project{Name: "Work", Members: []ProjectMember{{Name: "x", Role: "owner"}}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role: %d", w.Code)
+	}
+
+	// кому разрешен маршрут: v - viewer, e - editor, a - admin проекта
+	// ("" - только администраторам сервера); маршруты задач - и в default,
+	// и под /projects/:pid
+	allowed := map[string]string{
+		"GET /auth/me":                      "vea",
+		"GET /all":                          "vea",
+		"GET /tasks":                        "vea",
+		"GET /task/:id":                     "vea",
+		"GET /tasks/:id":                    "vea",
+		"GET /task/:id/graph":               "vea",
+		"GET /task/:id/history":             "vea",
+		"GET /events":                       "vea",
+		"GET /events/stream":                "vea",
+		"GET /search":                       "vea",
+		"GET /export":                       "vea",
+		"GET /trash":                        "vea",
+		"POST /task":                        "ea",
+		"POST /tasks":                       "ea",
+		"POST /tasks/batch":                 "ea",
+		"PUT /task/:id":                     "ea",
+		"PUT /tasks/:id":                    "ea",
+		"PATCH /task/:id":                   "ea",
+		"PATCH /tasks/:id":                  "ea",
+		"DELETE /tasks/:id":                 "ea",
+		"POST /import":                      "ea",
+		"POST /trash/:id/restore":           "ea",
+		"POST /tasks/:id/move":              "ea",
+		"DELETE /trash/:id":                 "a",
+		"DELETE /trash":                     "a",
+		"GET /projects":                     "vea",
+		"POST /projects":                    "vea", // роль на сервере, не в проекте
+		"GET /projects/:pid":                "vea",
+		"PUT /projects/:pid":                "a",
+		"DELETE /projects/:pid":             "a",
+		"POST /webhooks":                    "",
+		"GET /webhooks":                     "",
+		"GET /webhooks/dead":                "",
+		"POST /webhooks/dead/:id/redeliver": "",
+		"DELETE /webhooks/dead/:id":         "",
+		"GET /webhooks/:id":                 "",
+		"PUT /webhooks/:id":                 "",
+		"DELETE /webhooks/:id":              "",
+		"GET /webhooks/:id/queue":           "",
+		"GET /metrics":                      "",
+	}
+	public := map[string]bool{"GET /": true, "POST /auth/token": true, "GET /healthz": true, "GET /readyz": true}
+	users := map[string]string{"viewer": "v", "editor": "e", "admin": "a", "root": "*"}
+
+	covered := make(map[string]bool)
+	for _, route := range s.r.Routes() {
+		key := route.Method + " " + route.Path
+		if public[key] {
+			continue
+		}
+		spec, ok := allowed[key]
+		if !ok {
+			spec, ok = allowed[route.Method+" "+strings.TrimPrefix(route.Path, projectPrefix)]
+		}
+		if !ok {
+			t.Errorf("%s: route is missing from the test matrix", key)
+			continue
+		}
+		if _, ok := routePolicy(route.Method, route.Path); !ok {
+			t.Errorf("%s: route has no policy", key)
+		}
+		covered[key] = true
+		covered[route.Method+" "+strings.TrimPrefix(route.Path, projectPrefix)] = true
+
+		// несуществующие ID: разрешенный запрос доходит до обработчика и
+		// получает 404/400, запрещенный отсекается раньше с 403
+		url := strings.NewReplacer(":pid", "work", ":id", "missing").Replace(route.Path)
+		if route.Path == "/projects/:pid" && route.Method == http.MethodDelete {
+			url = "/projects/work" // непустой: 409
+		}
+		for user, letter := range users {
+			w := do(user, route.Method, url, map[string]any{})
+			want := letter == "*" || strings.Contains(spec, letter)
+			var resp struct{ Reason string }
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			switch {
+			case want && (w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized):
+				t.Errorf("%s %s as %s: %d %s", route.Method, url, user, w.Code, w.Body)
+			case !want && (w.Code != http.StatusForbidden || resp.Reason != reasonRole):
+				t.Errorf("%s %s as %s: want 403 %s, got %d %s", route.Method, url, user, reasonRole, w.Code, w.Body)
+			}
+		}
+	}
+	for key := range allowed {
+		if !covered[key] {
+			t.Errorf("%s: no such route", key)
+		}
+	}
+
+	// подробности отказа - для программ
+	w := do("viewer", http.MethodPost, "/task", Task{Title: "x"})
+	var resp map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusForbidden || resp["reason"] != reasonRole || resp["role"] != "viewer" || resp["required_role"] != "editor" {
+		t.Fatalf("viewer POST /task: %d %s", w.Code, w.Body)
+	}
+
+	// вне проекта его нет вовсе; в default без записи в members - editor
+	if w := do("carol", http.MethodGet, "/projects/work/all", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("outsider: %d", w.Code)
+	}
+	if w := do("carol", http.MethodPost, "/task", Task{Title: "carol's"}); w.Code != http.StatusOK {
+		t.Fatalf("default project editor: %d %s", w.Code, w.Body)
+	}
+
+	// для переноса нужна роль editor и в проекте назначения
+	tasks := decodeTasks(t, do("carol", http.MethodGet, "/all", nil))
+	do("root", http.MethodPut, "/projects/work", Project{Name: "Work", Members: []ProjectMember{{Name: "carol", Role: roleViewer}}})
+	w = do("carol", http.MethodPost, "/tasks/"+tasks[0].ID+"/move", map[string]string{"project": "work"})
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusForbidden || resp["reason"] != reasonRole || resp["project"] != "work" {
+		t.Fatalf("move to a viewer's project: %d %s", w.Code, w.Body)
+	}
+	do("root", http.MethodPut, "/projects/work", Project{Name: "Work", Members: []ProjectMember{{Name: "carol", Role: roleEditor}}})
+	if w := do("carol", http.MethodPost, "/tasks/"+tasks[0].ID+"/move", map[string]string{"project": "work"}); w.Code != http.StatusOK {
+		t.Fatalf("move: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Полнотекстовый поиск по Title и Description: инвертированный индекс
+// (терм -> задачи -> позиции терма) с ранжированием BM25.
+//
+// Запрос GET /search?q= - термы через пробел, все должны найтись (and):
+//
+//	молоко хлеб      - оба слова
+//	"купить молоко"  - фраза (слова подряд)
+//	разраб*          - префикс (разработка, разработчик, ...)
+//
+// Индекс обновляется по одному документу при каждом изменении хранилища
+// (через TaskStore.Watch), а не перестраивается целиком.
+
+// параметры BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	titleBoost = 2   // термы заголовка весят вдвое больше
+	fieldGap   = 100 // разрыв позиций между Title и Description (фраза не склеит поля)
+)
+
+var errEmptyQuery = errors.New("empty search query")
+
+// searchDoc - термы одной задачи с позициями
+type searchDoc struct {
+	terms     map[string][]int
+	descStart int // позиции >= descStart относятся к Description
+	length    int // длина документа с учетом titleBoost
+}
+
+// tf - частота терма в документе с учетом titleBoost
+func (d searchDoc) tf(term string) float64 {
+	var tf float64
+	for _, pos := range d.terms[term] {
+		if pos < d.descStart {
+			tf += titleBoost
+		} else {
+			tf++
+		}
+	}
+	return tf
+}
+
+// searchIndex - инвертированный индекс задач
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[string][]int // терм -> ID задачи -> позиции
+	docs     map[string]searchDoc        // ID задачи -> ее термы
+	terms    []string                    // все термы по алфавиту (для префиксов)
+	totalLen int                         // сумма длин документов (для средней длины)
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string][]int),
+		docs:     make(map[string]searchDoc),
+	}
+}
+
+// tokenize разбивает текст на слова (буквы и цифры любого алфавита)
+// в нижнем регистре; "ё" приравнивается к "е"
+func tokenize(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// update - taskWatcher: переиндексирует одну задачу
+func (x *searchIndex) update(op string, before, after Task) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// задачи в корзине не ищутся
+	switch op {
+	case opUpdate:
+		x.remove(before.ID)
+		x.add(after)
+	case opTrash, opDelete:
+		x.remove(before.ID)
+	default:
+		x.add(after)
+	}
+}
+
+// add индексирует задачу (вызывается под x.mu)
+func (x *searchIndex) add(task Task) {
+	title, desc := tokenize(task.Title), tokenize(task.Description)
+	doc := searchDoc{
+		terms:     make(map[string][]int),
+		descStart: len(title) + fieldGap,
+		length:    titleBoost*len(title) + len(desc),
+	}
+	for i, term := range title {
+		doc.terms[term] = append(doc.terms[term], i)
+	}
+	for i, term := range desc {
+		doc.terms[term] = append(doc.terms[term], doc.descStart+i)
+	}
+
+	for term, positions := range doc.terms {
+		docs, ok := x.postings[term]
+		if !ok {
+			docs = make(map[string][]int)
+			x.postings[term] = docs
+			i := sort.SearchStrings(x.terms, term)
+			x.terms = append(x.terms[:i], append([]string{term}, x.terms[i:]...)...)
+		}
+		docs[task.ID] = positions
+	}
+	x.docs[task.ID] = doc
+	x.totalLen += doc.length
+}
+
+// remove убирает задачу из индекса (вызывается под x.mu)
+func (x *searchIndex) remove(id string) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		docs := x.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(x.postings, term)
+			i := sort.SearchStrings(x.terms, term)
+			x.terms = append(x.terms[:i], x.terms[i+1:]...)
+		}
+	}
+	delete(x.docs, id)
+	x.totalLen -= doc.length
+}
+
+// searchClause - часть запроса: слово, префикс или фраза
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseSearchQuery разбирает строку запроса на части
+func parseSearchQuery(q string) ([]searchClause, error) {
+	var clauses []searchClause
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		if q[0] == '"' {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			if terms := tokenize(phrase); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			q = rest
+			continue
+		}
+		word, rest, _ := strings.Cut(q, " ")
+		q = rest
+		terms := tokenize(word)
+		for i, term := range terms {
+			// "*" относится только к последнему слову (например, "e-mail*")
+			prefix := strings.HasSuffix(word, "*") && i == len(terms)-1
+			clauses = append(clauses, searchClause{terms: []string{term}, prefix: prefix})
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, errEmptyQuery
+	}
+	return clauses, nil
+}
+
+// searchHit - найденная задача и ее оценка
+type searchHit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// search ищет задачи по запросу q и возвращает их по убыванию оценки
+func (x *searchIndex) search(q string) ([]searchHit, error) {
+	clauses, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var scores map[string]float64
+	for _, clause := range clauses {
+		matched := x.match(clause)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		// все части запроса должны найтись
+		for id, score := range scores {
+			if m, ok := matched[id]; ok {
+				scores[id] = score + m
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits, nil
+}
+
+// match возвращает задачи, подходящие под часть запроса, с оценками BM25
+// (вызывается под x.mu)
+func (x *searchIndex) match(clause searchClause) map[string]float64 {
+	scores := make(map[string]float64)
+	switch {
+	case clause.prefix:
+		term := clause.terms[0]
+		for i := sort.SearchStrings(x.terms, term); i < len(x.terms) && strings.HasPrefix(x.terms[i], term); i++ {
+			for id := range x.postings[x.terms[i]] {
+				scores[id] += x.bm25(x.terms[i], id)
+			}
+		}
+	case len(clause.terms) == 1:
+		for id := range x.postings[clause.terms[0]] {
+			scores[id] = x.bm25(clause.terms[0], id)
+		}
+	default:
+		for id := range x.postings[clause.terms[0]] {
+			if x.hasPhrase(id, clause.terms) {
+				for _, term := range clause.terms {
+					scores[id] += x.bm25(term, id)
+				}
+			}
+		}
+	}
+	return scores
+}
+
+// hasPhrase проверяет, что термы идут в задаче подряд
+func (x *searchIndex) hasPhrase(id string, terms []string) bool {
+	doc := x.docs[id]
+next:
+	for _, start := range doc.terms[terms[0]] {
+		for k, term := range terms[1:] {
+			if !containsInt(doc.terms[term], start+k+1) {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func containsInt(list []int, v int) bool {
+	i := sort.SearchInts(list, v)
+	return i < len(list) && list[i] == v
+}
+
+// bm25 - вклад терма в оценку задачи
+func (x *searchIndex) bm25(term, id string) float64 {
+	n := float64(len(x.docs))
+	df := float64(len(x.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	doc := x.docs[id]
+	tf := doc.tf(term)
+	avg := float64(x.totalLen) / n
+	if avg == 0 {
+		avg = 1
+	}
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avg))
+}
+
+// обработчик запроса GET /search?q=  &limit=
+func (h *handlers) searchTasks(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	limit = min(limit, currentConfig().Tasks.MaxPerPage)
+
+	hits, err := h.search.search(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// задачи читаются из хранилища после поиска (не под блокировкой индекса);
+	// удаленную за это время задачу, как и чужую, просто пропускаем
+	type result struct {
+		Task  Task    `json:"task"`
+		Score float64 `json:"score"`
+	}
+	p := principalFrom(c)
+	items := make([]result, 0, min(limit, len(hits)))
+	total := 0
+	for _, hit := range hits {
+		task, err := h.visibleTask(p, hit.ID)
+		if err != nil {
+			continue
+		}
+		total++
+		if len(items) < limit {
+			items = append(items, result{Task: h.withBlocked(task), Score: hit.Score})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"query": c.Query("q"), "total": total, "items": items})
+}
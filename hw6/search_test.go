@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestIndex - индекс с задачами tasks
+func newTestIndex(tasks ...Task) *searchIndex {
+	x := newSearchIndex()
+	for _, task := range tasks {
+		x.update(opCreate, Task{}, task)
+	}
+	return x
+}
+
+// searchIDs - ID найденных задач по порядку
+func searchIDs(t *testing.T, x *searchIndex, q string) string {
+	t.Helper()
+	hits, err := x.search(q)
+	if err != nil {
+		t.Fatalf("search(%q): %v", q, err)
+	}
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSearchRanking(t *testing.T) {
+	x := newTestIndex(
+		Task{ID: "1", Title: "Хлеб", Description: "молоко"},
+		Task{ID: "2", Title: "Хлеб", Description: "молоко, снова молоко"},
+		Task{ID: "3", Title: "Молоко"},
+		Task{ID: "4", Title: "Сыр"},
+		Task{ID: "5", Title: "Хлеб", Description: "молоко"},
+	)
+	// заголовок весомее описания, два вхождения - одного,
+	// при равной оценке - по ID
+	if got := searchIDs(t, x, "молоко"); got != "3,2,1,5" {
+		t.Fatalf("молоко: %s", got)
+	}
+	// все слова должны найтись, оценки складываются: в длинной задаче 2
+	// "хлеб" весит меньше, и это перевешивает второе "молоко"
+	if got := searchIDs(t, x, "хлеб МОЛОКО"); got != "1,5,2" {
+		t.Fatalf("хлеб молоко: %s", got)
+	}
+	// редкое слово весомее частого
+	hits, _ := x.search("сыр")
+	common, _ := x.search("хлеб")
+	if len(hits) != 1 || len(common) != 3 || hits[0].Score <= common[0].Score {
+		t.Fatalf("idf: %v %v", hits, common)
+	}
+	if got := searchIDs(t, x, "сыр хлеб"); got != "" {
+		t.Fatalf("сыр хлеб: %s", got)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	x := newTestIndex(
+		Task{ID: "1", Title: "Купить молоко", Description: "и хлеб"},
+		Task{ID: "2", Title: "Молоко", Description: "купить завтра"},
+		Task{ID: "3", Title: "Купить", Description: "молоко"},
+		Task{ID: "4", Title: "Разработка", Description: "разработчик ищет e-mail"},
+		Task{ID: "5", Title: "Раз два", Description: "ещё раз"},
+	)
+	for _, tc := range []struct{ q, want string }{
+		{`"купить молоко"`, "1"},
+		// фраза не склеивает конец заголовка и начало описания (fieldGap)
+		{`"молоко купить"`, ""},
+		{`"купить молоко" хлеб`, "1"},
+		{`разраб*`, "4"},
+		{`раз*`, "4,5"},
+		{`раз`, "5"},
+		{`e-mail*`, "4"},
+		{`ма*`, ""},
+		// ё = е, регистр не важен
+		{`ЕЩЕ`, "5"},
+	} {
+		if got := searchIDs(t, x, tc.q); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.q, got, tc.want)
+		}
+	}
+	for _, q := range []string{"", "  ", `"`, "!?"} {
+		if _, err := x.search(q); err != errEmptyQuery {
+			t.Errorf("search(%q) = %v", q, err)
+		}
+	}
+}
+
+func TestSearchUpdate(t *testing.T) {
+	task := Task{ID: "1", Title: "Отчет", Description: "квартальный"}
+	x := newTestIndex(task, Task{ID: "2", Title: "Отчет"})
+
+	// старые термы после изменения не находятся
+	changed := Task{ID: "1", Title: "Доклад"}
+	x.update(opUpdate, task, changed)
+	if got := searchIDs(t, x, "отчет"); got != "2" {
+		t.Fatalf("after update: %s", got)
+	}
+	if got := searchIDs(t, x, "квартал*"); got != "" {
+		t.Fatalf("old prefix after update: %s", got)
+	}
+
+	x.update(opTrash, changed, changed)
+	if got := searchIDs(t, x, "доклад"); got != "" {
+		t.Fatalf("after trash: %s", got)
+	}
+	x.update(opRestore, changed, changed)
+	if got := searchIDs(t, x, "доклад"); got != "1" {
+		t.Fatalf("after restore: %s", got)
+	}
+	x.update(opDelete, changed, Task{})
+	x.update(opDelete, Task{ID: "2", Title: "Отчет"}, Task{})
+	if len(x.docs) != 0 || len(x.postings) != 0 || len(x.terms) != 0 || x.totalLen != 0 {
+		t.Fatalf("index not empty: %+v", x)
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "Позвонить в банк"}, nil)
+	s.ok("", http.MethodPost, "/task", Task{Title: "Банк", Description: "открыть счет"}, nil)
+
+	type result struct {
+		Total int `json:"total"`
+		Items []struct {
+			Task  Task    `json:"task"`
+			Score float64 `json:"score"`
+		} `json:"items"`
+	}
+	var res result
+	s.ok("", http.MethodGet, "/search?q=банк&limit=1", nil, &res)
+	if res.Total != 2 || len(res.Items) != 1 || res.Items[0].Task.Title != "Банк" {
+		t.Fatalf("search: %+v", res)
+	}
+	id := res.Items[0].Task.ID
+
+	// задача в корзине не ищется, после восстановления - снова ищется
+	s.ok("", http.MethodDelete, "/tasks/"+id, nil, nil)
+	s.ok("", http.MethodGet, "/search?q=банк", nil, &res)
+	if res.Total != 1 || res.Items[0].Task.ID == id {
+		t.Fatalf("after trash: %+v", res)
+	}
+	s.ok("", http.MethodPost, "/trash/"+id+"/restore", nil, nil)
+	s.ok("", http.MethodGet, "/search?q=счет", nil, &res)
+	if res.Total != 1 || res.Items[0].Task.ID != id {
+		t.Fatalf("after restore: %+v", res)
+	}
+
+	for _, url := range []string{"/search", "/search?q=%20", "/search?q=банк&limit=0"} {
+		if w := s.do("", http.MethodGet, url, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", url, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errTaskNotFound - задачи с таким ID нет в хранилище
+var errTaskNotFound = errors.New("task not found")
+
+// errVersionMismatch - задача изменилась после того, как клиент ее прочитал
+var errVersionMismatch = errors.New("task version mismatch")
+
+// errTaskExists - задача с таким ID уже есть (в том числе в корзине)
+var errTaskExists = errors.New("task already exists")
+
+// errNotSaved - изменение принято в памяти, но не записано на диск
+// (обработчики отвечают кодом 207, как и раньше)
+var errNotSaved = errors.New("task changes not saved")
+
+// errNotLogged - изменение не записано в журнал предзаписи и поэтому не
+// выполнено (обработчики отвечают кодом 500)
+var errNotLogged = errors.New("task changes not logged")
+
+// TaskStore - интерфейс хранилища задач.
+// Обработчики работают только через него, поэтому хранилище
+// (память, JSON-файл и т.д.) можно менять, не трогая createTask/updateTask/deleteTask.
+type TaskStore interface {
+	// Get возвращает копию задачи по ID или errTaskNotFound
+	Get(id string) (Task, error)
+	// List возвращает копию всех задач в порядке добавления (по возрастанию Seq)
+	List() []Task
+	// Visible возвращает копию задач, доступных по одному из ключей keys
+	// (см. accessKeys), в порядке добавления - без перебора остальных задач
+	Visible(keys []string) []Task
+	// Create добавляет задачу, присваивает ей ID (UUID v.4) и Seq и возвращает ее
+	Create(task Task) (Task, error)
+	// Update заменяет задачу с тем же ID, если ее текущая версия равна task.Version
+	// (иначе errVersionMismatch), и увеличивает версию
+	Update(task Task) (Task, error)
+	// Delete переносит задачу task.ID в корзину (задает DeletedAt);
+	// task.Version != 0 - только если версия совпадает; task.UpdatedBy - кто
+	// удалил (остальные поля не нужны)
+	Delete(task Task) error
+	// Trash возвращает копию задач в корзине в порядке удаления.
+	// Get, List, Count и Watch задачи из корзины не видят.
+	Trash() []Task
+	// Restore возвращает задачу task.ID из корзины (errTaskNotFound, если ее
+	// там нет) с блокерами task.BlockedBy; task.UpdatedBy - кто восстановил
+	Restore(task Task) (Task, error)
+	// Purge окончательно удаляет задачу task.ID из корзины
+	Purge(task Task) error
+	// Batch выполняет изменения ops (opCreate, opUpdate, opTrash, opDelete -
+	// по тем же правилам, что Create, Update, Delete и Purge) все или ни
+	// одного, с одной записью на диск. opCreate с непустым ID создает задачу с этим ID, если он
+	// свободен (иначе errTaskExists) - так импорт сохраняет ID и зависимости.
+	// Возвращает задачи после каждого изменения; ошибка - *batchError
+	Batch(ops []change) ([]Task, error)
+	// Count возвращает число задач
+	Count() int
+	// Revision возвращает номер ревизии хранилища (растет при каждом изменении)
+	Revision() uint64
+	// Watch подписывает w на изменения; сначала w получает все
+	// существующие задачи как opLoad
+	Watch(w taskWatcher)
+}
+
+// taskWatcher получает каждое изменение хранилища: для opCreate и opLoad
+// before пустая, для opDelete (удаление из корзины) в after только ID
+// и UpdatedBy (кто удалил).
+// Вызывается под блокировкой хранилища в порядке изменений, поэтому должен
+// работать быстро и не обращаться к хранилищу.
+type taskWatcher func(op string, before, after Task)
+
+// memoryStore - хранилище задач в памяти:
+// срез задач (порядок добавления) + индекс [ID] = индекс задачи в срезе.
+// Удаленные задачи лежат в отдельном срезе trash (порядок удаления).
+// access - индекс доступа: [ключ доступа] = ID задач (см. access.go).
+// Gin вызывает обработчики в разных горутинах, поэтому срезы и индекс
+// защищены мьютексом: чтение под RLock, изменения (и запись на диск) под Lock.
+type memoryStore struct {
+	mu    sync.RWMutex
+	tasks []Task
+	index map[string]int
+	trash []Task
+	// access обновляется в notify, поэтому учитывает все изменения
+	access map[string]map[string]bool
+	seq    uint64 // последний выданный Task.Seq
+	rev    uint64 // ревизия: число изменений
+	// logChange - запись до изменения (журнал предзаписи): если она не
+	// удалась, изменение не выполняется; save - запись после изменения
+	// (снимок); nil - только память
+	logChange func(ch change) error
+	save      func(ch change) error
+
+	watchers []taskWatcher
+}
+
+// операции над задачами (записи журнала, события)
+const (
+	opCreate  = "create"
+	opUpdate  = "update"
+	opDelete  = "delete" // окончательное удаление (из корзины)
+	opTrash   = "trash"  // перенос в корзину
+	opRestore = "restore"
+	opBatch   = "batch" // несколько изменений одной записью (см. Batch)
+	opLoad    = "load"  // не изменение: задача уже была в хранилище (см. Watch)
+)
+
+// change - одно изменение хранилища: операция и задача
+// (для удаления достаточно ID)
+type change struct {
+	Op      string   `json:"op"`
+	Task    Task     `json:"task"`
+	Changes []change `json:"changes,omitempty"` // изменения пакета (opBatch)
+}
+
+// batchError - ошибка операции пакета с номером Index (с нуля)
+type batchError struct {
+	Index int
+	Err   error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+// newMemoryStore создает хранилище в памяти с начальным набором задач
+// (задачи с DeletedAt попадают в корзину).
+// Задачам без Seq (файлы старого формата) номера выдаются по порядку в срезе,
+// задачи без версии получают версию 1.
+func newMemoryStore(tasks []Task) *memoryStore {
+	s := &memoryStore{access: make(map[string]map[string]bool)}
+	for _, task := range tasks {
+		s.seq = max(s.seq, task.Seq)
+	}
+	for _, task := range tasks {
+		if task.Seq == 0 {
+			s.seq++
+			task.Seq = s.seq
+		}
+		task.Version = max(task.Version, 1)
+		if task.DeletedAt != nil {
+			s.trash = append(s.trash, task)
+		} else {
+			s.tasks = append(s.tasks, task)
+			s.updateAccess(opCreate, Task{}, task)
+		}
+	}
+	s.createIndex()
+	return s
+}
+
+// all - задачи вместе с корзиной для записи снимка (вызывается под s.mu)
+func (s *memoryStore) all() []Task {
+	all := make([]Task, 0, len(s.tasks)+len(s.trash))
+	all = append(all, s.tasks...)
+	return append(all, s.trash...)
+}
+
+// trashed - индекс задачи id в корзине или -1 (вызывается под s.mu).
+// Корзина обычно маленькая, поэтому отдельного индекса для нее нет.
+func (s *memoryStore) trashed(id string) int {
+	for i, task := range s.trash {
+		if task.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert вставляет задачу в срез по Seq, чтобы List оставался упорядоченным
+// (вызывается под s.mu)
+func (s *memoryStore) insert(task Task) {
+	i := sort.Search(len(s.tasks), func(i int) bool { return s.tasks[i].Seq > task.Seq })
+	s.tasks = append(s.tasks[:i], append([]Task{task}, s.tasks[i:]...)...)
+	s.createIndex()
+}
+
+// remove удаляет i-ю задачу из среза и обновляет индекс (вызывается под s.mu)
+func (s *memoryStore) remove(i int) Task {
+	task := s.tasks[i]
+	s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+	s.createIndex()
+	return task
+}
+
+// createIndex перестраивает индекс по срезу задач (вызывается под s.mu)
+func (s *memoryStore) createIndex() {
+	s.index = make(map[string]int, len(s.tasks))
+	for i, task := range s.tasks {
+		s.index[task.ID] = i
+	}
+}
+
+// apply применяет изменение без записи на диск (вызывается под s.mu).
+// Повторное применение не меняет результат: create/update работают
+// как "вставить или заменить" (задача ищется и в корзине: если она уже
+// там, запись журнала старше снимка и пропускается), перенос в корзину
+// не создает второй копии, а перенос в корзину и обратно отсутствующей
+// задачи (уже перенесенной) игнорируется, как и удаление отсутствующей.
+// Это нужно для воспроизведения журнала поверх снимка.
+func (s *memoryStore) apply(ch change) {
+	if ch.Op == opBatch {
+		for _, c := range ch.Changes {
+			s.apply(c)
+		}
+		return
+	}
+	s.rev++
+	i, ok := s.index[ch.Task.ID]
+	t := s.trashed(ch.Task.ID)
+	switch {
+	case ch.Op == opTrash && ok:
+		before := s.remove(i)
+		if t >= 0 {
+			s.trash[t] = ch.Task
+		} else {
+			s.trash = append(s.trash, ch.Task)
+		}
+		s.notify(opTrash, before, ch.Task)
+	case ch.Op == opRestore && t >= 0:
+		before := s.trash[t]
+		s.trash = append(s.trash[:t], s.trash[t+1:]...)
+		s.insert(ch.Task)
+		s.notify(opRestore, before, ch.Task)
+	case ch.Op == opDelete && t >= 0:
+		before := s.trash[t]
+		s.trash = append(s.trash[:t], s.trash[t+1:]...)
+		s.notify(opDelete, before, ch.Task)
+	case ch.Op == opDelete && ok:
+		// журнал старого формата: удаление без корзины
+		before := s.remove(i)
+		s.notify(opDelete, before, ch.Task)
+	case ch.Op == opTrash || ch.Op == opRestore || ch.Op == opDelete:
+	case t >= 0:
+		// create/update задачи, которая в снимке уже в корзине
+	case ok:
+		before := s.tasks[i]
+		s.tasks[i] = ch.Task
+		s.notify(opUpdate, before, ch.Task)
+	default:
+		s.tasks = append(s.tasks, ch.Task)
+		s.index[ch.Task.ID] = len(s.tasks) - 1
+		s.seq = max(s.seq, ch.Task.Seq)
+		s.notify(opCreate, Task{}, ch.Task)
+	}
+}
+
+// notify обновляет индекс доступа и передает изменение подписчикам
+// (вызывается под s.mu)
+func (s *memoryStore) notify(op string, before, after Task) {
+	s.updateAccess(op, before, after)
+	for _, w := range s.watchers {
+		w(op, before, after)
+	}
+}
+
+// updateAccess обновляет индекс доступа после изменения (вызывается под s.mu):
+// задача before уходит из индекса, а after (если она не удалена) добавляется
+func (s *memoryStore) updateAccess(op string, before, after Task) {
+	if before.ID != "" {
+		for _, key := range accessKeys(before) {
+			delete(s.access[key], before.ID)
+			if len(s.access[key]) == 0 {
+				delete(s.access, key)
+			}
+		}
+	}
+	if op != opCreate && op != opUpdate && op != opRestore {
+		return
+	}
+	for _, key := range accessKeys(after) {
+		if s.access[key] == nil {
+			s.access[key] = make(map[string]bool)
+		}
+		s.access[key][after.ID] = true
+	}
+}
+
+func (s *memoryStore) Watch(w taskWatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range s.tasks {
+		w(opLoad, Task{}, task)
+	}
+	s.watchers = append(s.watchers, w)
+}
+
+// commit записывает изменение ch в журнал (s.logChange), применяет его
+// и сохраняет (s.save) (вызывается под s.mu). Если журнал не записан,
+// хранилище не меняется, и ошибка оборачивается в errNotLogged; ошибка
+// s.save - в errNotSaved (изменение уже выполнено).
+func (s *memoryStore) commit(ch change) error {
+	if s.logChange != nil {
+		err := s.logChange(ch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errNotLogged, err)
+		}
+	}
+	s.apply(ch)
+	if s.save == nil {
+		return nil
+	}
+	err := s.save(ch)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotSaved, err)
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.index[id]
+	if !ok {
+		return Task{}, errTaskNotFound
+	}
+	return s.tasks[i], nil
+}
+
+func (s *memoryStore) List() []Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Task, len(s.tasks))
+	copy(list, s.tasks)
+	return list
+}
+
+func (s *memoryStore) Visible(keys []string) []Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []Task
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		for id := range s.access[key] {
+			if !seen[id] {
+				seen[id] = true
+				list = append(list, s.tasks[s.index[id]])
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Seq < list[j].Seq })
+	return list
+}
+
+func (s *memoryStore) Create(task Task) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// генерируем строковый ID (UUID v.4) и порядковый номер
+	task.ID = uuid.NewString()
+	task.Seq = s.seq + 1
+	task.Version = 1
+	stampTask(&task, Task{}, time.Now())
+	return task, s.commit(change{Op: opCreate, Task: task})
+}
+
+func (s *memoryStore) Update(task Task) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index[task.ID]
+	if !ok {
+		return Task{}, errTaskNotFound
+	}
+	if task.Version != s.tasks[i].Version {
+		return Task{}, errVersionMismatch
+	}
+	// порядковый номер не меняется, версия растет
+	before := s.tasks[i]
+	task.Seq = before.Seq
+	task.Version++
+	stampTask(&task, before, time.Now())
+	return task, s.commit(change{Op: opUpdate, Task: task})
+}
+
+func (s *memoryStore) Delete(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index[task.ID]
+	if !ok {
+		return errTaskNotFound
+	}
+	if task.Version != 0 && task.Version != s.tasks[i].Version {
+		return errVersionMismatch
+	}
+	// задача уходит в корзину как новая версия (см. apply)
+	trashed := trashTask(s.tasks[i], task.UpdatedBy, time.Now())
+	return s.commit(change{Op: opTrash, Task: trashed})
+}
+
+// trashTask - задача task после переноса в корзину автором actor в момент now
+func trashTask(task Task, actor string, now time.Time) Task {
+	task.Version++
+	task.UpdatedAt = &now
+	task.UpdatedBy = actor
+	task.DeletedAt = &now
+	return task
+}
+
+func (s *memoryStore) Batch(ops []change) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Сначала все изменения готовятся и проверяются так, будто предыдущие
+	// операции пакета уже выполнены, и только потом применяются.
+	// changed - задачи, уже измененные пакетом (удаленные - с DeletedAt).
+	changed := make(map[string]Task)
+	purged := make(map[string]bool)
+	get := func(id string) (Task, bool) {
+		if purged[id] {
+			return Task{}, false
+		}
+		if task, ok := changed[id]; ok {
+			return task, task.DeletedAt == nil
+		}
+		i, ok := s.index[id]
+		if !ok {
+			return Task{}, false
+		}
+		return s.tasks[i], true
+	}
+	now := time.Now()
+	seq := s.seq
+	changes := make([]change, len(ops))
+	tasks := make([]Task, len(ops))
+	for i, op := range ops {
+		task := op.Task
+		switch op.Op {
+		case opCreate:
+			_, live := s.index[task.ID]
+			_, seen := changed[task.ID]
+			switch {
+			case task.ID == "":
+				task.ID = uuid.NewString()
+			case live || seen || purged[task.ID] || s.trashed(task.ID) >= 0:
+				return nil, &batchError{Index: i, Err: errTaskExists}
+			}
+			seq++
+			task.Seq = seq
+			task.Version = 1
+			stampTask(&task, Task{}, now)
+		case opUpdate:
+			before, ok := get(task.ID)
+			if !ok {
+				return nil, &batchError{Index: i, Err: errTaskNotFound}
+			}
+			if task.Version != before.Version {
+				return nil, &batchError{Index: i, Err: errVersionMismatch}
+			}
+			task.Seq = before.Seq
+			task.Version++
+			stampTask(&task, before, now)
+		case opTrash:
+			before, ok := get(task.ID)
+			if !ok {
+				return nil, &batchError{Index: i, Err: errTaskNotFound}
+			}
+			if task.Version != 0 && task.Version != before.Version {
+				return nil, &batchError{Index: i, Err: errVersionMismatch}
+			}
+			task = trashTask(before, task.UpdatedBy, now)
+		case opDelete:
+			// задача в корзине - та, что была там до пакета или перенесена
+			// туда пакетом
+			before, seen := changed[task.ID]
+			inTrash := !seen && s.trashed(task.ID) >= 0 || seen && before.DeletedAt != nil
+			if !inTrash || purged[task.ID] {
+				return nil, &batchError{Index: i, Err: errTaskNotFound}
+			}
+			task = Task{ID: task.ID, UpdatedBy: task.UpdatedBy}
+			purged[task.ID] = true
+		default:
+			return nil, &batchError{Index: i, Err: fmt.Errorf("unknown operation %q", op.Op)}
+		}
+		changed[task.ID] = task
+		changes[i] = change{Op: op.Op, Task: task}
+		tasks[i] = task
+	}
+
+	return tasks, s.commit(change{Op: opBatch, Changes: changes})
+}
+
+func (s *memoryStore) Trash() []Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Task, len(s.trash))
+	copy(list, s.trash)
+	return list
+}
+
+func (s *memoryStore) Restore(task Task) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.trashed(task.ID)
+	if t < 0 {
+		return Task{}, errTaskNotFound
+	}
+	// задача возвращается на свое место (по Seq) как новая версия
+	before := s.trash[t]
+	now := time.Now()
+	restored := before
+	restored.Version++
+	restored.UpdatedAt = &now
+	restored.UpdatedBy = task.UpdatedBy
+	restored.DeletedAt = nil
+	restored.BlockedBy = task.BlockedBy
+	return restored, s.commit(change{Op: opRestore, Task: restored})
+}
+
+func (s *memoryStore) Purge(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.trashed(task.ID)
+	if t < 0 {
+		return errTaskNotFound
+	}
+	purged := Task{ID: task.ID, UpdatedBy: task.UpdatedBy}
+	return s.commit(change{Op: opDelete, Task: purged})
+}
+
+func (s *memoryStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.tasks)
+}
+
+// stampTask проставляет время создания, изменения и выполнения задачи.
+// Эти поля задает только сервер: значения от клиента заменяются
+// (DeletedAt сбрасывается: в корзину задачи попадают только через Delete).
+func stampTask(task *Task, before Task, now time.Time) {
+	task.DeletedAt = nil
+	task.CreatedAt = before.CreatedAt
+	if task.CreatedAt == nil {
+		task.CreatedAt = &now
+	}
+	task.UpdatedAt = &now
+
+	switch {
+	case !task.Status:
+		task.CompletedAt = nil
+	case before.Status && before.CompletedAt != nil:
+		task.CompletedAt = before.CompletedAt
+	default:
+		// задача только что выполнена
+		task.CompletedAt = &now
+	}
+}
+
+func (s *memoryStore) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rev
+}
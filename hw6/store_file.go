@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// fileStore - хранилище в памяти, которое после каждого изменения
+// переписывает все задачи (и корзину) в JSON-файл (как раньше делал main.go)
+type fileStore struct {
+	*memoryStore
+	path string
+}
+
+// newFileStore читает задачи из файла path и создает хранилище
+func newFileStore(path string) (*fileStore, error) {
+	tasks, err := loadTasksFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{memoryStore: newMemoryStore(tasks), path: path}
+	s.save = func(change) error {
+		return observeWrite("file", func() error { return saveTasksToFile(path, s.all()) })
+	}
+	return s, nil
+}
+
+// Writable проверяет, можно ли записать файл задач (см. readyz)
+func (s *fileStore) Writable() error {
+	return checkWritable(filepath.Dir(s.path))
+}
+
+func saveTasksToFile(path string, tasks []Task) error {
+	jsonData, err := json.MarshalIndent(tasks, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, jsonData, 0644)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func loadTasksFromFile(path string) ([]Task, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return nil, f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// пустой файл (только что созданный) - это пустой список задач
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var tasks []Task
+	err = json.Unmarshal(data, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hammerStore параллельно создает, читает, обновляет и удаляет задачи
+// (запускать с go test -race)
+func hammerStore(t *testing.T, store TaskStore) {
+	const workers, rounds = 16, 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				task, err := store.Create(Task{Title: fmt.Sprintf("w%d-%d", w, i)})
+				if err != nil {
+					t.Errorf("create: %v", err)
+					return
+				}
+				task.Priority = uint8(i)
+				if _, err = store.Update(task); err != nil {
+					t.Errorf("update: %v", err)
+					return
+				}
+				if _, err = store.Get(task.ID); err != nil {
+					t.Errorf("get: %v", err)
+					return
+				}
+				_ = store.List()
+				_ = store.Count()
+				if i%2 == 0 {
+					if err = store.Delete(Task{ID: task.ID}); err != nil {
+						t.Errorf("delete: %v", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	want := workers * rounds / 2
+	if n := store.Count(); n != want {
+		t.Fatalf("Count() = %d, want %d", n, want)
+	}
+	// индекс должен совпадать со срезом
+	for _, task := range store.List() {
+		got, err := store.Get(task.ID)
+		if err != nil || got.ID != task.ID {
+			t.Fatalf("Get(%s) = %v, %v", task.ID, got.ID, err)
+		}
+	}
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	hammerStore(t, newMemoryStore(nil))
+}
+
+func TestFileStoreConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	store, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hammerStore(t, store)
+
+	// файл содержит то же, что и память (вместе с корзиной)
+	tasks, err := loadTasksFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Count() + len(store.Trash()); len(tasks) != n {
+		t.Fatalf("file has %d tasks, store has %d", len(tasks), n)
+	}
+}
+
+func TestHandlersConcurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := newRouter(newHandlers(newMemoryStore(nil), nil, nil, nil))
+
+	do := func(method, url string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, url, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if rec := do(http.MethodPost, "/task", Task{Title: "t"}); rec.Code != http.StatusOK {
+					t.Errorf("POST /task: %d %s", rec.Code, rec.Body)
+					return
+				}
+				var all []Task
+				rec := do(http.MethodGet, "/all", nil)
+				if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil {
+					t.Errorf("GET /all: %v", err)
+					return
+				}
+				do(http.MethodGet, "/tasks?page=1", nil)
+				if len(all) > 0 {
+					task := all[i%len(all)]
+					do(http.MethodPut, "/task/"+task.ID, Task{Title: "u", Priority: 1})
+					if i%3 == 0 {
+						do(http.MethodDelete, "/tasks/"+task.ID, nil)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWALStoreConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	store, _, err := newWALStore(filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hammerStore(t, store)
+}
+
+func TestWALStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, logPath := filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal")
+
+	store, _, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.Create(Task{Title: "a"})
+	b, _ := store.Create(Task{Title: "b"})
+	b.Priority = 7
+	b, _ = store.Update(b)
+	_ = store.Delete(a)
+	_, _ = store.Create(Task{Title: "c"})
+	// "падение": журнал не сжат, снимок пустой
+	store.log.Close()
+
+	// дописываем обрезанную запись
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`0badc0de {"op":"create","task":{"id":"x"`)
+	f.Close()
+
+	store, report, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if report.Replayed != 5 || !errors.Is(report.Err, errTruncatedRecord) {
+		t.Fatalf("report = %s", report)
+	}
+	if store.Count() != 2 {
+		t.Fatalf("Count() = %d, want 2", store.Count())
+	}
+	got, err := store.Get(b.ID)
+	if err != nil || got.Priority != 7 {
+		t.Fatalf("Get(b) = %+v, %v", got, err)
+	}
+	// удаленная задача восстановлена в корзину
+	trash := store.Trash()
+	if len(trash) != 1 || trash[0].ID != a.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("Trash() = %+v", trash)
+	}
+	// после восстановления журнал сжат в снимок (вместе с корзиной)
+	tasks, err := loadTasksFromFile(snapshot)
+	if err != nil || len(tasks) != 3 {
+		t.Fatalf("snapshot = %v, %v", tasks, err)
+	}
+}
+
+func TestWALStoreBatch(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, logPath := filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal")
+
+	store, _, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.Create(Task{Title: "a"})
+
+	// ошибка в любой операции - не выполняется ничего
+	_, err = store.Batch([]change{
+		{Op: opCreate, Task: Task{Title: "b"}},
+		{Op: opUpdate, Task: Task{ID: a.ID, Title: "a2", Version: 7}},
+	})
+	var berr *batchError
+	if !errors.As(err, &berr) || berr.Index != 1 || !errors.Is(err, errVersionMismatch) {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if store.Count() != 1 || store.Revision() != 1 {
+		t.Fatalf("failed batch changed store: count %d, revision %d", store.Count(), store.Revision())
+	}
+
+	// операции видят результат предыдущих, пакет - одна запись журнала
+	done, err := store.Batch([]change{
+		{Op: opCreate, Task: Task{Title: "b"}},
+		{Op: opUpdate, Task: Task{ID: a.ID, Title: "a2", Version: 1}},
+		{Op: opUpdate, Task: Task{ID: a.ID, Title: "a3", Version: 2}},
+		{Op: opTrash, Task: Task{ID: a.ID}},
+	})
+	if err != nil || len(done) != 4 || done[3].Version != 4 {
+		t.Fatalf("Batch() = %+v, %v", done, err)
+	}
+	if store.records != 2 {
+		t.Fatalf("log has %d records, want 2", store.records)
+	}
+	store.log.Close()
+
+	store, report, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if report.Replayed != 2 || store.Count() != 1 || len(store.Trash()) != 1 || store.Trash()[0].Title != "a3" {
+		t.Fatalf("after replay: %s, count %d, trash %+v", report, store.Count(), store.Trash())
+	}
+}
+
+func TestWALStoreReplayOverSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, logPath := filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal")
+
+	store, _, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.Create(Task{Title: "a"})
+	a.Priority = 3
+	a, _ = store.Update(a)
+	_ = store.Delete(a)
+	b, _ := store.Create(Task{Title: "b"})
+	_ = store.Delete(b)
+	b, _ = store.Restore(b)
+	b.Priority = 5
+	_, _ = store.Update(b)
+	_, _ = store.Create(Task{Title: "c"})
+	// "падение" в compact: снимок записан, а журнал еще не очищен
+	if err := writeSnapshot(snapshot, store.all()); err != nil {
+		t.Fatal(err)
+	}
+	store.log.Close()
+
+	store, report, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if report.Replayed != 8 || store.Count() != 2 {
+		t.Fatalf("after replay: %s, count %d", report, store.Count())
+	}
+	trash := store.Trash()
+	if len(trash) != 1 || trash[0].ID != a.ID || trash[0].Priority != 3 || trash[0].DeletedAt == nil {
+		t.Fatalf("Trash() = %+v", trash)
+	}
+	if got, err := store.Get(b.ID); err != nil || got.Priority != 5 {
+		t.Fatalf("Get(b) = %+v, %v", got, err)
+	}
+}
+
+func TestWALStoreWriteAhead(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, logPath := filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal")
+
+	store, _, err := newWALStore(snapshot, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.Create(Task{Title: "a"})
+
+	// журнал не записан - изменение не выполнено
+	store.log.Close()
+	_, err = store.Create(Task{Title: "b"})
+	if !errors.Is(err, errNotLogged) || storeStatus(err) != http.StatusInternalServerError {
+		t.Fatalf("Create() error = %v", err)
+	}
+	a.Title = "a2"
+	_, err = store.Update(a)
+	if !errors.Is(err, errNotLogged) {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if store.Count() != 1 || store.Revision() != 1 {
+		t.Fatalf("unlogged change applied: count %d, revision %d", store.Count(), store.Revision())
+	}
+	if got, _ := store.Get(a.ID); got.Title != "a" {
+		t.Fatalf("Get(a) = %+v", got)
+	}
+}
+
+func TestWALStoreCorruption(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, logPath := filepath.Join(dir, "tasks.json"), filepath.Join(dir, "tasks.wal")
+	write := func(records ...string) {
+		if err := os.WriteFile(logPath, []byte(strings.Join(records, "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record := func(title string) string {
+		line, _ := encodeRecord(change{Op: opCreate, Task: Task{ID: title, Title: title, Seq: 1}})
+		return string(line)
+	}
+	bad := "0badc0de {\"op\":\"create\"}\n"
+
+	// плохая последняя запись - недописанная, отрезается
+	write(record("a"), bad)
+	store, report, err := newWALStore(snapshot, logPath)
+	if err != nil || report.Replayed != 1 || !errors.Is(report.Err, errBadRecord) {
+		t.Fatalf("torn tail: %s, %v", report, err)
+	}
+	store.Close()
+
+	// плохая запись в середине - ошибка, журнал не трогается
+	_ = os.Remove(snapshot)
+	write(record("a"), bad, record("b"))
+	_, _, err = newWALStore(snapshot, logPath)
+	if !errors.Is(err, errBadRecord) {
+		t.Fatalf("corrupt middle: %v", err)
+	}
+	data, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(data), record("b")) {
+		t.Fatalf("log truncated: %q", data)
+	}
+}
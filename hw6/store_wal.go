@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// snapshotEvery - через сколько записей журнала состояние сжимается в снимок
+const snapshotEvery = 100
+
+// errBadRecord - испорченная запись журнала (не совпала контрольная сумма или JSON)
+var errBadRecord = errors.New("corrupt log record")
+
+// errTruncatedRecord - последняя запись журнала обрезана (нет перевода строки)
+var errTruncatedRecord = errors.New("truncated log record")
+
+// walStore - хранилище в памяти с журналом предзаписи (write-ahead log).
+//
+// Файл снимка (tasks.json) больше не переписывается на месте после каждого
+// изменения: изменение дописывается одной строкой в журнал и сбрасывается
+// на диск (fsync), и только потом применяется в памяти; если запись не
+// удалась, изменение не выполняется, а недописанная строка отрезается.
+// Каждые snapshotEvery записей состояние сжимается в новый снимок (временный
+// файл -> fsync -> rename), и журнал очищается.
+// При старте журнал воспроизводится поверх последнего снимка.
+type walStore struct {
+	*memoryStore
+	snapshotPath string
+	logPath      string
+	log          *os.File
+	size         int64 // длина журнала: до нее отрезается недописанная запись
+	records      int   // записей в журнале после последнего снимка
+}
+
+// walReport - что было восстановлено при старте
+type walReport struct {
+	Snapshot  int    // задач в снимке
+	Replayed  int    // применено записей журнала
+	BadOffset int64  // смещение первой плохой записи (-1 - журнал целый)
+	BadFile   string // куда сохранен отброшенный хвост журнала
+	Err       error  // errTruncatedRecord или errBadRecord
+}
+
+func (r walReport) String() string {
+	s := fmt.Sprintf("snapshot: %d tasks, log: %d records replayed", r.Snapshot, r.Replayed)
+	if r.Err != nil {
+		s += fmt.Sprintf("; %v at offset %d, tail saved to %s", r.Err, r.BadOffset, r.BadFile)
+	}
+	return s
+}
+
+// newWALStore загружает снимок snapshotPath, воспроизводит журнал logPath
+// и открывает журнал для записи. Плохая последняя запись (недописанная при
+// падении) не является ошибкой: она сохраняется в отдельный файл, отрезается
+// и описывается в walReport. Плохая запись в середине журнала - ошибка:
+// отрезать ее вместе со следующими значило бы потерять подтвержденные изменения.
+func newWALStore(snapshotPath, logPath string) (*walStore, walReport, error) {
+	report := walReport{BadOffset: -1}
+
+	tasks, err := loadTasksFromFile(snapshotPath)
+	if err != nil {
+		return nil, report, fmt.Errorf("snapshot %s: %w", snapshotPath, err)
+	}
+	report.Snapshot = len(tasks)
+
+	s := &walStore{
+		memoryStore:  newMemoryStore(tasks),
+		snapshotPath: snapshotPath,
+		logPath:      logPath,
+	}
+	err = s.replay(&report)
+	if err != nil {
+		return nil, report, err
+	}
+
+	s.log, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, report, err
+	}
+	s.size, err = s.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		s.log.Close()
+		return nil, report, err
+	}
+	// сразу сжимаем воспроизведенный журнал в снимок
+	if report.Replayed > 0 {
+		err = s.compact()
+		if err != nil {
+			s.log.Close()
+			return nil, report, err
+		}
+	}
+	s.logChange = func(ch change) error {
+		return observeWrite("wal", func() error { return s.appendRecord(ch) })
+	}
+	s.save = func(change) error {
+		s.compactIfDue()
+		return nil
+	}
+	return s, report, nil
+}
+
+// replay применяет записи журнала по порядку; плохая запись допустима
+// только последней
+func (s *walStore) replay(report *walReport) error {
+	data, err := os.ReadFile(s.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			report.Err = errTruncatedRecord
+			break
+		}
+		ch, err := decodeRecord(data[:n])
+		if err != nil && n+1 < len(data) {
+			return fmt.Errorf("log %s: %w at offset %d followed by more records", s.logPath, err, offset)
+		}
+		if err != nil {
+			report.Err = err
+			break
+		}
+		s.apply(ch)
+		report.Replayed++
+		offset += int64(n + 1)
+		data = data[n+1:]
+	}
+	if report.Err == nil {
+		return nil
+	}
+
+	// сохраняем плохой хвост для разбора и отрезаем его от журнала
+	report.BadOffset = offset
+	report.BadFile = s.logPath + ".bad-" + strconv.FormatInt(offset, 10)
+	err = os.WriteFile(report.BadFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(s.logPath, offset)
+}
+
+// encodeRecord - строка журнала: "<crc32 в hex> <JSON изменения>\n"
+func encodeRecord(ch change) ([]byte, error) {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(data))
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+// decodeRecord разбирает строку журнала (без '\n') и проверяет контрольную сумму
+func decodeRecord(line []byte) (change, error) {
+	var ch change
+	sum, data, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return ch, errBadRecord
+	}
+	crc, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE(data) {
+		return ch, errBadRecord
+	}
+	err = json.Unmarshal(data, &ch)
+	if err != nil {
+		return ch, errBadRecord
+	}
+	return ch, nil
+}
+
+// appendRecord дописывает изменение в журнал и сбрасывает его на диск
+// (вызывается под s.mu до применения изменения). Если запись не удалась,
+// журнал обрезается до прежней длины, чтобы следующие записи не легли
+// после недописанной.
+func (s *walStore) appendRecord(ch change) error {
+	line, err := encodeRecord(ch)
+	if err != nil {
+		return err
+	}
+	_, err = s.log.Write(line)
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		if terr := s.log.Truncate(s.size); terr != nil {
+			return errors.Join(err, terr)
+		}
+		return err
+	}
+	s.size += int64(len(line))
+	s.records++
+	return nil
+}
+
+// compactIfDue сжимает журнал в снимок каждые snapshotEvery записей
+// (вызывается под s.mu после применения изменения). Ошибка сжатия не
+// ошибка изменения: оно уже в журнале; сжатие повторится со следующей записью.
+func (s *walStore) compactIfDue() {
+	if s.records < snapshotEvery {
+		return
+	}
+	err := s.compact()
+	if err != nil {
+		log.Printf("compact %s: %v", s.logPath, err)
+	}
+}
+
+// compact записывает снимок текущего состояния и очищает журнал.
+// Если процесс упадет между записью снимка и очисткой журнала,
+// журнал будет повторно применен к новому снимку - это безопасно (см. apply).
+func (s *walStore) compact() error {
+	err := observeWrite("snapshot", func() error { return writeSnapshot(s.snapshotPath, s.all()) })
+	if err != nil {
+		return err
+	}
+	err = s.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	s.size, s.records = 0, 0
+	return s.log.Sync()
+}
+
+// Close сжимает журнал в снимок и закрывает файл журнала
+func (s *walStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.compact()
+	if cerr := s.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Writable проверяет, можно ли записать снимок (см. readyz)
+func (s *walStore) Writable() error {
+	return checkWritable(filepath.Dir(s.snapshotPath))
+}
+
+// writeSnapshot атомарно заменяет файл снимка (см. writeFileAtomic)
+func writeSnapshot(path string, tasks []Task) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(tasks)
+	})
+}
+
+// writeFileAtomic атомарно заменяет файл path тем, что запишет write:
+// запись во временный файл в том же каталоге, fsync, rename, fsync каталога
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// при ошибке удаляем временный файл (после rename его уже нет)
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
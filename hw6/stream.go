@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// Поток изменений задач: GET /events/stream (Server-Sent Events).
+//
+// Каждое событие истории (history.go) отправляется сообщением без имени
+// (его получает EventSource.onmessage): id - номер события, data - событие
+// JSON вместе с задачей после изменения (для удаления - последней версией).
+// Клиент, переподключаясь, присылает Last-Event-ID (или ?last_event_id=)
+// и получает пропущенные события из буфера последних streamBuffer событий.
+// Если нужных событий в буфере уже нет, приходит событие "reset" - клиенту
+// нужно перечитать задачи через GET /all.
+//
+// Параметры ?filter=, ?status=, ?priority= - как в GET /all (см. filter.go).
+
+var streamBuffer = 1000                // событий в буфере для Last-Event-ID
+var streamQueue = 64                   // очередь событий одного клиента
+var streamHeartbeat = 15 * time.Second // комментарий-пинг, чтобы прокси не закрывали соединение
+
+// streamEvent - событие потока: событие истории и задача
+type streamEvent struct {
+	Event
+	Task Task `json:"task"`
+}
+
+// eventHub рассылает события подписчикам и хранит последние из них
+type eventHub struct {
+	mu   sync.Mutex
+	buf  []streamEvent // последние события по возрастанию Seq
+	size int
+	last uint64 // номер последнего события
+	subs map[chan streamEvent]bool
+
+	// listeners получают каждое событие сразу, без очереди и отключений
+	// (вызываются под hub.mu, поэтому должны работать быстро)
+	listeners []func(streamEvent)
+}
+
+// newEventHub создает рассылку, последнее событие которой - last
+func newEventHub(size int, last uint64) *eventHub {
+	return &eventHub{size: size, last: last, subs: make(map[chan streamEvent]bool)}
+}
+
+// publish добавляет событие в буфер и рассылает его. Не блокируется:
+// подписчик, который не успевает читать, отключается (и переподключится
+// с Last-Event-ID).
+func (hub *eventHub) publish(e streamEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.buf = append(hub.buf, e)
+	if len(hub.buf) > 2*hub.size {
+		// сдвигаем буфер не на каждое событие, а когда он вырос вдвое
+		hub.buf = append([]streamEvent(nil), hub.buf[len(hub.buf)-hub.size:]...)
+	}
+	hub.last = e.Seq
+
+	for _, listen := range hub.listeners {
+		listen(e)
+	}
+	for ch := range hub.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(hub.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe подписывает на события после события с номером after
+// (resume=false - только на новые). Возвращает события из буфера, которые
+// клиент пропустил, канал новых событий и false, если часть пропущенных
+// событий уже не в буфере.
+func (hub *eventHub) subscribe(after uint64, resume bool) ([]streamEvent, chan streamEvent, bool) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	ch := make(chan streamEvent, streamQueue)
+	hub.subs[ch] = true
+	if !resume {
+		return nil, ch, true
+	}
+
+	buf := hub.buf
+	if len(buf) > hub.size {
+		buf = buf[len(buf)-hub.size:]
+	}
+	oldest := hub.last + 1
+	if len(buf) > 0 {
+		oldest = buf[0].Seq
+	}
+	if after > hub.last || after+1 < oldest {
+		return nil, ch, false
+	}
+	var missed []streamEvent
+	for _, e := range buf {
+		if e.Seq > after {
+			missed = append(missed, e)
+		}
+	}
+	return missed, ch, true
+}
+
+// listen подписывает fn на все новые события
+func (hub *eventHub) listen(fn func(streamEvent)) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.listeners = append(hub.listeners, fn)
+}
+
+// unsubscribe отписывает канал ch (если он еще не отключен)
+func (hub *eventHub) unsubscribe(ch chan streamEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.subs[ch] {
+		delete(hub.subs, ch)
+		close(ch)
+	}
+}
+
+// обработчик запроса GET /events/stream?filter=  &status=  &priority=
+func (h *handlers) streamEvents(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if query.Has("due") || query.Has("due_within") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "due filters are not supported in the stream"})
+		return
+	}
+	filter, err := filterFromQuery(query, time.Now())
+	if err != nil {
+		filterQueryError(c, err)
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		after, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be an event number"})
+			return
+		}
+	}
+
+	missed, ch, ok := h.events.hub.subscribe(after, lastID != "")
+	defer h.events.hub.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx не должен буферизовать поток
+	c.Status(http.StatusOK)
+
+	p := principalFrom(c)
+	send := func(e streamEvent) {
+		if p.canSee(e.Task) && (filter == nil || filter.eval(e.Task)) {
+			c.Render(-1, sse.Event{Id: strconv.FormatUint(e.Seq, 10), Data: e})
+		}
+	}
+	if !ok {
+		c.Render(-1, sse.Event{Event: "reset", Data: gin.H{"error": "missed events are no longer buffered"}})
+	}
+	for _, e := range missed {
+		send(e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, open := <-ch:
+			if !open {
+				return // клиент не успевал читать и отключен
+			}
+			send(e)
+		case <-heartbeat.C:
+			_, err = c.Writer.WriteString(": ping\n\n")
+			if err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseMessage - сообщение потока событий
+type sseMessage struct {
+	ID    string
+	Event string
+	Data  streamEvent
+}
+
+// readSSE читает из r сообщения потока (комментарии-пинги пропускаются),
+// пока их не наберется n или поток не закончится
+func readSSE(t *testing.T, r *bufio.Reader, n int) []sseMessage {
+	t.Helper()
+	var list []sseMessage
+	var msg sseMessage
+	for n < 0 || len(list) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\n")
+		name, value, _ := strings.Cut(line, ":")
+		switch name {
+		case "id":
+			msg.ID = value
+		case "event":
+			msg.Event = value
+		case "data":
+			if msg.Event == "" {
+				if err := json.Unmarshal([]byte(value), &msg.Data); err != nil {
+					t.Fatalf("data %s: %v", value, err)
+				}
+			}
+		case "":
+			if line == "" && (msg.ID != "" || msg.Event != "") {
+				list = append(list, msg)
+				msg = sseMessage{}
+			}
+		}
+	}
+	return list
+}
+
+// streamIDs - номера событий (и reset) через запятую
+func streamIDs(list []sseMessage) string {
+	var ids []string
+	for _, msg := range list {
+		if msg.Event != "" {
+			ids = append(ids, msg.Event)
+		} else {
+			ids = append(ids, msg.ID)
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestStreamResume(t *testing.T) {
+	defer func(n int) { streamBuffer = n }(streamBuffer)
+	streamBuffer = 3
+	s := newTestServer(t, nil)
+	for i := 1; i <= 5; i++ {
+		s.ok("", http.MethodPost, "/task", Task{Title: "t", Priority: uint8(i)}, nil)
+	}
+
+	// поток сразу закрывается: в ответе только пропущенные события
+	resume := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := s.request("", http.MethodGet, url, nil, header...)
+		ctx, cancel := context.WithCancel(req.Context())
+		cancel()
+		return s.serve(req.WithContext(ctx))
+	}
+	for _, tc := range []struct{ url, lastID, want string }{
+		{"/events/stream", "", ""},
+		{"/events/stream", "3", "4,5"},
+		{"/events/stream", "2", "3,4,5"},
+		{"/events/stream", "5", ""},
+		{"/events/stream?last_event_id=4", "", "5"},
+		{"/events/stream?last_event_id=1", "4", "5"},
+		{"/events/stream?filter=priority%3E3", "2", "4,5"},
+		// событий 1 и 2 уже нет в буфере, а 9 еще не было: вместо
+		// неполного списка - reset, и клиент перечитывает задачи
+		{"/events/stream", "1", "reset"},
+		{"/events/stream", "0", "reset"},
+		{"/events/stream", "9", "reset"},
+	} {
+		var header []string
+		if tc.lastID != "" {
+			header = []string{"Last-Event-ID", tc.lastID}
+		}
+		w := resume(tc.url, header...)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("%s %s: %d %s", tc.url, tc.lastID, w.Code, w.Body)
+		}
+		if got := streamIDs(readSSE(t, bufio.NewReader(w.Body), -1)); got != tc.want {
+			t.Errorf("%s Last-Event-ID %s: %s, want %s", tc.url, tc.lastID, got, tc.want)
+		}
+	}
+
+	for _, url := range []string{"/events/stream?last_event_id=x", "/events/stream?due=today", "/events/stream?filter=owner=x"} {
+		if w := resume(url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", url, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStreamPrincipals(t *testing.T) {
+	s := newTestServer(t, nil, apiKey{Name: "alice"}, apiKey{Name: "bob"})
+	srv := httptest.NewServer(s.r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream", nil)
+	req.Header.Set("X-API-Key", "alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+
+	// чужие задачи в поток не попадают, пока их не откроют
+	s.ok("bob", http.MethodPost, "/task", Task{Title: "bob's"}, nil)
+	s.ok("alice", http.MethodPost, "/task", Task{Title: "alice's"}, nil)
+	bobs := decodeTasks(t, s.do("bob", http.MethodGet, "/all", nil))[0]
+	s.ok("bob", http.MethodPatch, "/task/"+bobs.ID, map[string]any{"shared_with": []string{"alice"}}, nil)
+
+	got := readSSE(t, stream, 2)
+	if streamIDs(got) != "2,3" || got[0].Data.Task.Title != "alice's" || got[0].Data.Type != "created" ||
+		got[1].Data.Task.ID != bobs.ID || got[1].Data.Type != "updated" {
+		t.Fatalf("alice got %+v", got)
+	}
+
+	// переподключение с Last-Event-ID тоже не отдает чужих событий
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream", nil)
+	req.Header.Set("X-API-Key", "alice-key")
+	req.Header.Set("Last-Event-ID", "0")
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if got := streamIDs(readSSE(t, bufio.NewReader(resp2.Body), 2)); got != "2,3" {
+		t.Fatalf("alice resumed %s", got)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Корзина: DELETE /tasks/:id не удаляет задачу, а переносит ее в корзину
+// (задается DeletedAt). Задачи в корзине не видны в /all, /tasks, /task/:id
+// и поиске, но их можно вернуть:
+//
+//	GET    /trash              - задачи в корзине
+//	POST   /trash/:id/restore  - вернуть задачу
+//	DELETE /trash/:id          - удалить задачу навсегда
+//	DELETE /trash              - очистить корзину
+//
+// Через tasks.trash_retention (config.go; 0 - никогда) задача удаляется
+// из корзины сама (sweepTrash).
+
+var trashSweepEvery = time.Minute // как часто проверяется срок хранения
+
+// actorRetention - автор удалений по сроку хранения (в истории)
+const actorRetention = "retention"
+
+// обработчик запроса GET /trash
+func (h *handlers) listTrash(c *gin.Context) {
+	c.JSON(http.StatusOK, h.visibleTrash(principalFrom(c)))
+}
+
+// обработчик запроса POST /trash/:id/restore
+func (h *handlers) restoreTask(c *gin.Context) {
+	id := c.Param("id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var task Task
+	trash := h.store.Trash()
+	for _, t := range trash {
+		if t.ID == id {
+			task = t
+		}
+	}
+	if task.ID == "" || !principalFrom(c).canSee(task) {
+		storeError(c, errTaskNotFound)
+		return
+	}
+
+	// блокеры, удаленные навсегда, пока задача лежала в корзине, отбрасываем;
+	// остальные ребра возвращаются вместе с задачей и не должны дать цикл
+	tasks := taskMap(h.store.List())
+	inTrash := taskMap(trash)
+	var blockers []string
+	for _, b := range task.BlockedBy {
+		if _, ok := tasks[b]; ok {
+			blockers = append(blockers, b)
+		} else if _, ok := inTrash[b]; ok {
+			blockers = append(blockers, b)
+		}
+	}
+	if path := findPath(blockers, id, tasks); path != nil {
+		dependencyError(c, fmt.Errorf("%w: %v", errDependencyCycle, append([]string{id}, path...)))
+		return
+	}
+
+	task.BlockedBy = blockers
+	task.UpdatedBy = actorFrom(c)
+	task, err := h.store.Restore(task)
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusOK, h.withBlocked(task))
+}
+
+// обработчик запроса DELETE /trash/:id
+func (h *handlers) purgeTask(c *gin.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := c.Param("id")
+	if !slices.ContainsFunc(h.visibleTrash(principalFrom(c)), func(t Task) bool { return t.ID == id }) {
+		storeError(c, errTaskNotFound)
+		return
+	}
+	err := h.purge([]string{id}, actorFrom(c))
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "task purged"})
+}
+
+// обработчик запроса DELETE /trash
+func (h *handlers) emptyTrash(c *gin.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// каждый очищает только свою часть корзины
+	var ids []string
+	for _, task := range h.visibleTrash(principalFrom(c)) {
+		ids = append(ids, task.ID)
+	}
+	err := h.purge(ids, actorFrom(c))
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "trash emptied", "purged": len(ids)})
+}
+
+// purge удаляет задачи ids из корзины навсегда и убирает их из blocked_by
+// остальных задач - одним пакетом, то есть одной записью на диск
+// (вызывается под h.mu)
+func (h *handlers) purge(ids []string, actor string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ops := make([]change, 0, len(ids))
+	for _, id := range ids {
+		ops = append(ops, change{Op: opDelete, Task: Task{ID: id, UpdatedBy: actor}})
+	}
+	_, err := h.store.Batch(append(ops, h.unblockChanges(ids, actor)...))
+	return err
+}
+
+// expireTrash удаляет навсегда задачи, пролежавшие в корзине дольше
+// tasks.trash_retention к моменту now, и возвращает их число
+func (h *handlers) expireTrash(now time.Time) (int, error) {
+	retention := time.Duration(currentConfig().Tasks.TrashRetention)
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ids []string
+	for _, task := range h.store.Trash() {
+		if !task.DeletedAt.Add(retention).After(now) {
+			ids = append(ids, task.ID)
+		}
+	}
+	err := h.purge(ids, actorRetention)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// sweepTrash раз в every проверяет срок хранения задач в корзинах всех
+// проектов (запускается в отдельной горутине и работает до отмены ctx)
+func (h *handlers) sweepTrash(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for now := time.Now(); ; {
+		projects, release := h.projects.all()
+		for _, ph := range projects {
+			n, err := ph.expireTrash(now)
+			if err != nil {
+				log.Printf("trash %s: %v", ph.project, err)
+			}
+			if n > 0 {
+				log.Printf("trash %s: %d expired tasks purged", ph.project, n)
+			}
+		}
+		release()
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
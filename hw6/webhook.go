@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Исходящие вебхуки: о каждом событии истории (history.go) сервер сообщает
+// подписанным системам запросом POST на их URL. Тело - то же, что data
+// в GET /events/stream (событие и задача), заголовки:
+//
+//	X-Webhook-Id         - ID подписки
+//	X-Webhook-Delivery   - ID доставки (одинаковый во всех повторах)
+//	X-Webhook-Event      - тип события (created, updated, ...)
+//	X-Webhook-Timestamp  - время отправки (секунды Unix)
+//	X-Webhook-Signature  - "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + тело))
+//
+// Получатель проверяет подпись и время (защита от повтора старого запроса).
+// Ответ 2xx - доставлено; иначе доставка повторяется через webhookBackoff,
+// 2*webhookBackoff, ... (не больше webhookMaxBackoff), а после
+// webhookMaxAttempts попыток уходит в dead letters, откуда ее можно
+// отправить снова. Подписки и очередь хранятся в файле (webhooks.json)
+// и переживают перезапуск. Новые доставки не переписывают весь файл, а
+// дописываются в журнал рядом с ним (webhooks.json.queue); при следующей
+// записи файла журнал начинается заново.
+
+var webhookBackoff = time.Second        // пауза перед первым повтором
+var webhookMaxBackoff = time.Hour       // максимальная пауза между повторами
+var webhookMaxAttempts = 10             // попыток до dead letters
+var webhookTimeout = 10 * time.Second   // ожидание ответа получателя
+var webhookWorkers = 4                  // одновременных доставок
+var webhookIdleCheck = 30 * time.Second // проверка очереди, если не было событий
+
+var errWebhookNotFound = errors.New("webhook not found")
+var errDeliveryNotFound = errors.New("delivery not found")
+
+// Webhook - подписка на события
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url" binding:"required,url"`
+	Events    []string  `json:"events,omitempty"` // типы событий (пусто - все)
+	Secret    string    `json:"secret,omitempty"` // ключ подписи; показывается только при создании
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wants - нужно ли подписке событие типа typ
+func (w Webhook) wants(typ string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, typ)
+}
+
+// delivery - доставка события одной подписке
+type delivery struct {
+	ID         string      `json:"id"`
+	WebhookID  string      `json:"webhook_id"`
+	Event      streamEvent `json:"event"`
+	Attempts   int         `json:"attempts"`
+	NextAt     time.Time   `json:"next_at"`
+	LastStatus int         `json:"last_status,omitempty"` // код последнего ответа (0 - ответа не было)
+	LastError  string      `json:"last_error,omitempty"`
+}
+
+// webhookState - подписки и доставки (содержимое файла)
+type webhookState struct {
+	Webhooks []Webhook  `json:"webhooks"`
+	Queue    []delivery `json:"queue"`
+	Dead     []delivery `json:"dead"`
+}
+
+// webhookDispatcher хранит подписки и доставляет события
+type webhookDispatcher struct {
+	mu     sync.Mutex
+	state  webhookState
+	path   string   // "" - только в памяти
+	queue  *os.File // журнал новых доставок (nil - только в памяти)
+	client *http.Client
+	now    func() time.Time // часы (в тестах подменяются)
+	wake   chan struct{}    // в очереди появились доставки
+}
+
+// newWebhookDispatcher загружает подписки и очередь из файла path
+// ("" - только в памяти)
+func newWebhookDispatcher(path string) (*webhookDispatcher, error) {
+	d := &webhookDispatcher{
+		path:   path,
+		client: &http.Client{Timeout: webhookTimeout},
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+	if path == "" {
+		return d, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &d.state)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	err = d.openQueue(path + ".queue")
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// openQueue добавляет в очередь доставки из журнала path (поставленные после
+// последней записи файла) и открывает журнал для дописывания. Обрезанной
+// при падении может быть только последняя строка; испорченная строка
+// в середине журнала - ошибка.
+func (d *webhookDispatcher) openQueue(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, dl := range slices.Concat(d.state.Queue, d.state.Dead) {
+		known[dl.ID] = true
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	bad := 0
+	for line := 1; scanner.Scan(); line++ {
+		if bad > 0 {
+			f.Close()
+			return fmt.Errorf("%s: bad delivery at line %d followed by more deliveries", path, bad)
+		}
+		var dl delivery
+		err = json.Unmarshal(scanner.Bytes(), &dl)
+		if err != nil {
+			bad = line
+			continue
+		}
+		// доставка уже в файле (журнал не успел начаться заново)
+		// или ее подписку потом удалили
+		if known[dl.ID] || d.webhookIndex(dl.WebhookID) < 0 {
+			continue
+		}
+		known[dl.ID] = true
+		d.state.Queue = append(d.state.Queue, dl)
+	}
+	err = scanner.Err()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if bad > 0 {
+		log.Printf("webhooks %s: torn delivery at line %d skipped", path, bad)
+	}
+	d.queue = f
+	return nil
+}
+
+// save записывает состояние в файл (вызывается под d.mu);
+// ошибка записи оборачивается в errNotSaved
+func (d *webhookDispatcher) save() error {
+	if d.path == "" {
+		return nil
+	}
+	err := writeFileAtomic(d.path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(d.state)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotSaved, err)
+	}
+	// все доставки теперь в файле; если журнал не очистится, при загрузке
+	// его доставки, уже записанные в файл, пропускаются по ID
+	if d.queue != nil {
+		err = d.queue.Truncate(0)
+		if err != nil {
+			log.Printf("webhooks: queue log not truncated: %v", err)
+		}
+	}
+	return nil
+}
+
+// appendQueue дописывает новые доставки в журнал и сбрасывает его на диск
+// (вызывается под d.mu). Если дописать не удалось, записывается весь файл:
+// недописанная строка в журнале не должна оказаться перед следующими.
+func (d *webhookDispatcher) appendQueue(list []delivery) error {
+	if d.queue == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, dl := range list {
+		err := enc.Encode(dl)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := d.queue.Write(buf.Bytes())
+	if err == nil {
+		err = d.queue.Sync()
+	}
+	if err != nil {
+		log.Printf("webhooks: queue log: %v", err)
+		return d.save()
+	}
+	return nil
+}
+
+// notify будит run
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue - слушатель eventHub: ставит событие в очередь подходящих подписок.
+// Доставки дописываются в журнал до возврата, поэтому событие не потеряется,
+// даже если сервер остановится до доставки. enqueue вызывается под блокировкой
+// хранилища, поэтому весь файл подписок здесь не переписывается.
+func (d *webhookDispatcher) enqueue(e streamEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var queued []delivery
+	for _, w := range d.state.Webhooks {
+		if w.wants(e.Type) {
+			queued = append(queued, delivery{ID: uuid.NewString(), WebhookID: w.ID, Event: e, NextAt: d.now()})
+		}
+	}
+	if len(queued) == 0 {
+		return
+	}
+	d.state.Queue = append(d.state.Queue, queued...)
+	err := d.appendQueue(queued)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+	d.notify()
+}
+
+// run доставляет события из очереди, пока не отменен ctx
+func (d *webhookDispatcher) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		wait := webhookIdleCheck
+		if next := d.deliverDue(ctx); !next.IsZero() {
+			wait = min(wait, max(next.Sub(d.now()), 0))
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// deliverDue отправляет все доставки, время которых пришло, и возвращает
+// время следующей доставки в очереди (нулевое - очередь пуста)
+func (d *webhookDispatcher) deliverDue(ctx context.Context) time.Time {
+	d.mu.Lock()
+	now := d.now()
+	var due []delivery
+	for _, dl := range d.state.Queue {
+		if !dl.NextAt.After(now) {
+			due = append(due, dl)
+		}
+	}
+	hooks := make(map[string]Webhook, len(d.state.Webhooks))
+	for _, w := range d.state.Webhooks {
+		hooks[w.ID] = w
+	}
+	d.mu.Unlock()
+
+	// запросы - без блокировки: новые события в это время ставятся в очередь
+	status := make([]int, len(due))
+	errs := make([]error, len(due))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookWorkers)
+	for i := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			status[i], errs[i] = d.send(ctx, hooks[due[i].WebhookID], due[i])
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// ответы не засчитываются, если сервер останавливается
+	if ctx.Err() == nil {
+		for i, dl := range due {
+			d.finish(dl.ID, status[i], errs[i])
+		}
+		if len(due) > 0 {
+			err := d.save()
+			if err != nil {
+				log.Printf("webhooks: %v", err)
+			}
+		}
+	}
+	var next time.Time
+	for _, dl := range d.state.Queue {
+		if next.IsZero() || dl.NextAt.Before(next) {
+			next = dl.NextAt
+		}
+	}
+	return next
+}
+
+// finish записывает результат попытки доставки id (вызывается под d.mu)
+func (d *webhookDispatcher) finish(id string, status int, err error) {
+	i := slices.IndexFunc(d.state.Queue, func(dl delivery) bool { return dl.ID == id })
+	if i < 0 {
+		return // подписку удалили, пока шел запрос
+	}
+	dl := d.state.Queue[i]
+	if err == nil {
+		d.state.Queue = slices.Delete(d.state.Queue, i, i+1)
+		return
+	}
+	dl.Attempts++
+	dl.LastStatus, dl.LastError = status, err.Error()
+	if dl.Attempts >= webhookMaxAttempts {
+		log.Printf("webhooks: delivery %s to %s failed %d times: %v", dl.ID, dl.WebhookID, dl.Attempts, err)
+		d.state.Queue = slices.Delete(d.state.Queue, i, i+1)
+		d.state.Dead = append(d.state.Dead, dl)
+		return
+	}
+	dl.NextAt = d.now().Add(webhookRetryDelay(dl.Attempts))
+	d.state.Queue[i] = dl
+}
+
+// webhookRetryDelay - пауза после attempts неудачных попыток
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookMaxBackoff)
+}
+
+// send отправляет доставку dl подписке w и возвращает код ответа
+func (d *webhookDispatcher) send(ctx context.Context, w Webhook, dl delivery) (int, error) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hw6-webhooks")
+	req.Header.Set("X-Webhook-Id", w.ID)
+	req.Header.Set("X-Webhook-Delivery", dl.ID)
+	req.Header.Set("X-Webhook-Event", dl.Event.Type)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(w.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook - подпись тела body, отправленного в момент timestamp
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// create добавляет подписку (без секрета - с новым случайным)
+func (d *webhookDispatcher) create(w Webhook) (Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.ID = uuid.NewString()
+	w.CreatedAt = d.now()
+	if w.Secret == "" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return Webhook{}, err
+		}
+		w.Secret = hex.EncodeToString(key)
+	}
+	d.state.Webhooks = append(d.state.Webhooks, w)
+	return w, d.save()
+}
+
+// list возвращает подписки (без секретов)
+func (d *webhookDispatcher) list() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]Webhook, len(d.state.Webhooks))
+	for i, w := range d.state.Webhooks {
+		w.Secret = ""
+		list[i] = w
+	}
+	return list
+}
+
+// get возвращает подписку id (без секрета)
+func (d *webhookDispatcher) get(id string) (Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.webhookIndex(id)
+	if i < 0 {
+		return Webhook{}, errWebhookNotFound
+	}
+	w := d.state.Webhooks[i]
+	w.Secret = ""
+	return w, nil
+}
+
+// webhookIndex - индекс подписки id или -1 (вызывается под d.mu)
+func (d *webhookDispatcher) webhookIndex(id string) int {
+	return slices.IndexFunc(d.state.Webhooks, func(w Webhook) bool { return w.ID == id })
+}
+
+// update заменяет URL, типы событий и (если задан) секрет подписки w.ID
+func (d *webhookDispatcher) update(w Webhook) (Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.webhookIndex(w.ID)
+	if i < 0 {
+		return Webhook{}, errWebhookNotFound
+	}
+	old := d.state.Webhooks[i]
+	w.CreatedAt = old.CreatedAt
+	if w.Secret == "" {
+		w.Secret = old.Secret
+	}
+	d.state.Webhooks[i] = w
+	w.Secret = ""
+	return w, d.save()
+}
+
+// remove удаляет подписку вместе с ее доставками
+func (d *webhookDispatcher) remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.webhookIndex(id)
+	if i < 0 {
+		return errWebhookNotFound
+	}
+	d.state.Webhooks = slices.Delete(d.state.Webhooks, i, i+1)
+	ofWebhook := func(dl delivery) bool { return dl.WebhookID == id }
+	d.state.Queue = slices.DeleteFunc(d.state.Queue, ofWebhook)
+	d.state.Dead = slices.DeleteFunc(d.state.Dead, ofWebhook)
+	return d.save()
+}
+
+// pending возвращает доставки подписки id, ожидающие отправки
+func (d *webhookDispatcher) pending(id string) ([]delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.webhookIndex(id) < 0 {
+		return nil, errWebhookNotFound
+	}
+	list := []delivery{}
+	for _, dl := range d.state.Queue {
+		if dl.WebhookID == id {
+			list = append(list, dl)
+		}
+	}
+	return list, nil
+}
+
+// dead возвращает недоставленные события
+func (d *webhookDispatcher) dead() []delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]delivery{}, d.state.Dead...)
+}
+
+// redeliver возвращает доставку id из dead letters в очередь (с нуля попыток)
+func (d *webhookDispatcher) redeliver(id string) (delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.Dead, func(dl delivery) bool { return dl.ID == id })
+	if i < 0 {
+		return delivery{}, errDeliveryNotFound
+	}
+	dl := d.state.Dead[i]
+	dl.Attempts, dl.NextAt = 0, d.now()
+	d.state.Dead = slices.Delete(d.state.Dead, i, i+1)
+	d.state.Queue = append(d.state.Queue, dl)
+	d.notify()
+	return dl, d.save()
+}
+
+// dropDead удаляет доставку id из dead letters
+func (d *webhookDispatcher) dropDead(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.Dead, func(dl delivery) bool { return dl.ID == id })
+	if i < 0 {
+		return errDeliveryNotFound
+	}
+	d.state.Dead = slices.Delete(d.state.Dead, i, i+1)
+	return d.save()
+}
+
+// webhookError отправляет ответ на ошибку вебхуков
+func webhookError(c *gin.Context, err error) {
+	if errors.Is(err, errWebhookNotFound) || errors.Is(err, errDeliveryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	storeError(c, err)
+}
+
+// bindWebhook читает подписку из запроса и проверяет URL и типы событий
+func bindWebhook(c *gin.Context) (Webhook, bool) {
+	var w Webhook
+	err := c.BindJSON(&w)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return w, false
+	}
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be http or https"})
+		return w, false
+	}
+	for _, typ := range w.Events {
+		known := false
+		for _, t := range eventTypes {
+			known = known || t == typ
+		}
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown event type %q", typ)})
+			return w, false
+		}
+	}
+	return w, true
+}
+
+// обработчик запроса POST /webhooks (секрет возвращается только здесь)
+func (h *handlers) createWebhook(c *gin.Context) {
+	w, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+	w, err := h.hooks.create(w)
+	if err != nil && !errors.Is(err, errNotSaved) {
+		webhookError(c, err)
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"webhook": w, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, w)
+}
+
+// обработчик запроса GET /webhooks
+func (h *handlers) listWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, h.hooks.list())
+}
+
+// обработчик запроса GET /webhooks/:id
+func (h *handlers) getWebhook(c *gin.Context) {
+	w, err := h.hooks.get(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, w)
+}
+
+// обработчик запроса PUT /webhooks/:id (без secret - секрет не меняется)
+func (h *handlers) updateWebhook(c *gin.Context) {
+	w, ok := bindWebhook(c)
+	if !ok {
+		return
+	}
+	w.ID = c.Param("id")
+	w, err := h.hooks.update(w)
+	if err != nil && !errors.Is(err, errNotSaved) {
+		webhookError(c, err)
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusMultiStatus, gin.H{"webhook": w, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, w)
+}
+
+// обработчик запроса DELETE /webhooks/:id
+func (h *handlers) deleteWebhook(c *gin.Context) {
+	err := h.hooks.remove(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// обработчик запроса GET /webhooks/:id/queue - доставки, ожидающие отправки
+func (h *handlers) webhookQueue(c *gin.Context) {
+	list, err := h.hooks.pending(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// обработчик запроса GET /webhooks/dead
+func (h *handlers) deadDeliveries(c *gin.Context) {
+	c.JSON(http.StatusOK, h.hooks.dead())
+}
+
+// обработчик запроса POST /webhooks/dead/:id/redeliver
+func (h *handlers) redeliverWebhook(c *gin.Context) {
+	dl, err := h.hooks.redeliver(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dl)
+}
+
+// обработчик запроса DELETE /webhooks/dead/:id
+func (h *handlers) dropDeadDelivery(c *gin.Context) {
+	err := h.hooks.dropDead(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "delivery deleted"})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookReceiver - получатель вебхуков: проверяет подпись и отвечает
+// кодами из fail (по одному на запрос), а потом 200
+type webhookReceiver struct {
+	t      *testing.T
+	secret string
+
+	mu     sync.Mutex
+	fail   []int
+	events []streamEvent
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sig := "sha256=" + signWebhook(rcv.secret, r.Header.Get("X-Webhook-Timestamp"), body)
+	if r.Header.Get("X-Webhook-Signature") != sig {
+		rcv.t.Errorf("bad signature %q", r.Header.Get("X-Webhook-Signature"))
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.fail) > 0 {
+		w.WriteHeader(rcv.fail[0])
+		rcv.fail = rcv.fail[1:]
+		return
+	}
+	var e streamEvent
+	if err := json.Unmarshal(body, &e); err != nil || e.Type != r.Header.Get("X-Webhook-Event") {
+		rcv.t.Errorf("bad body %s: %v", body, err)
+	}
+	rcv.events = append(rcv.events, e)
+}
+
+func (rcv *webhookReceiver) received() []streamEvent {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]streamEvent(nil), rcv.events...)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(attempts int) { webhookMaxAttempts = attempts }(webhookMaxAttempts)
+	webhookMaxAttempts = 3
+
+	rcv := &webhookReceiver{t: t, secret: "s3cret", fail: []int{500, 503}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	hooks, err := newWebhookDispatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Now()
+	hooks.now = func() time.Time { return clock }
+
+	store := newMemoryStore(nil)
+	r := newRouter(newHandlers(store, nil, hooks, nil))
+	do := func(method, url string, body any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, url, bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/webhooks", Webhook{URL: srv.URL, Events: []string{eventUpdated}, Secret: rcv.secret})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create webhook: %d %s", w.Code, w.Body)
+	}
+	var hook Webhook
+	_ = json.Unmarshal(w.Body.Bytes(), &hook)
+	if w := do(http.MethodPost, "/webhooks", Webhook{URL: "ftp://x", Events: []string{"nope"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad webhook: %d", w.Code)
+	}
+
+	// created не подходит подписке, updated ставится в очередь
+	task, _ := store.Create(Task{Title: "a"})
+	task.Priority = 2
+	task, _ = store.Update(task)
+
+	ctx := context.Background()
+	// две неудачные попытки с растущей паузой, третья - успешная
+	for i, want := range []time.Duration{webhookRetryDelay(1), webhookRetryDelay(2)} {
+		next := hooks.deliverDue(ctx)
+		if got := next.Sub(clock); got != want {
+			t.Fatalf("attempt %d: next after %v, want %v", i+1, got, want)
+		}
+		// до срока повтор не отправляется
+		hooks.deliverDue(ctx)
+		clock = next
+	}
+	if next := hooks.deliverDue(ctx); !next.IsZero() {
+		t.Fatalf("queue not empty, next at %v", next)
+	}
+	got := rcv.received()
+	if len(got) != 1 || got[0].Type != eventUpdated || got[0].Task.ID != task.ID || got[0].Task.Priority != 2 {
+		t.Fatalf("received %+v", got)
+	}
+
+	// после webhookMaxAttempts неудач доставка уходит в dead letters
+	rcv.mu.Lock()
+	rcv.fail = []int{500, 500, 500}
+	rcv.mu.Unlock()
+	task.Priority = 3
+	_, _ = store.Update(task)
+	for i := 0; i < webhookMaxAttempts; i++ {
+		clock = clock.Add(webhookMaxBackoff)
+		hooks.deliverDue(ctx)
+	}
+	var dead []delivery
+	_ = json.Unmarshal(do(http.MethodGet, "/webhooks/dead", nil).Body.Bytes(), &dead)
+	if len(dead) != 1 || dead[0].Attempts != webhookMaxAttempts || dead[0].LastStatus != 500 {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	// очередь и dead letters переживают перезапуск
+	reloaded, err := newWebhookDispatcher(path)
+	if err != nil || len(reloaded.state.Webhooks) != 1 || len(reloaded.dead()) != 1 {
+		t.Fatalf("reloaded = %+v, %v", reloaded.state, err)
+	}
+
+	// повторная отправка из dead letters
+	if w := do(http.MethodPost, "/webhooks/dead/"+dead[0].ID+"/redeliver", nil); w.Code != http.StatusOK {
+		t.Fatalf("redeliver: %d %s", w.Code, w.Body)
+	}
+	hooks.deliverDue(ctx)
+	if got := rcv.received(); len(got) != 2 || got[1].Task.Priority != 3 {
+		t.Fatalf("received %+v", got)
+	}
+
+	// секрет не показывается после создания
+	w = do(http.MethodGet, "/webhooks/"+hook.ID, nil)
+	if w.Code != http.StatusOK || bytes.Contains(w.Body.Bytes(), []byte(rcv.secret)) {
+		t.Fatalf("get webhook: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWebhookQueueLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	hooks, err := newWebhookDispatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := hooks.create(Webhook{URL: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+
+	// новые доставки дописываются в журнал, файл подписок не переписывается
+	for _, typ := range []string{eventCreated, eventUpdated} {
+		hooks.enqueue(streamEvent{Event: Event{Type: typ}})
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, saved) {
+		t.Fatalf("webhooks file rewritten on enqueue:\n%s", data)
+	}
+	queue, _ := os.ReadFile(path + ".queue")
+	if n := bytes.Count(queue, []byte("\n")); n != 2 {
+		t.Fatalf("queue log has %d lines:\n%s", n, queue)
+	}
+	reloaded, err := newWebhookDispatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := reloaded.pending(hook.ID); len(list) != 2 || list[0].Event.Type != eventCreated || list[1].Event.Type != eventUpdated {
+		t.Fatalf("reloaded queue = %+v", list)
+	}
+
+	// запись файла начинает журнал заново; доставки не повторяются
+	if _, err := hooks.update(hook); err != nil {
+		t.Fatal(err)
+	}
+	if queue, _ := os.ReadFile(path + ".queue"); len(queue) != 0 {
+		t.Fatalf("queue log after save:\n%s", queue)
+	}
+	reloaded, _ = newWebhookDispatcher(path)
+	if list, _ := reloaded.pending(hook.ID); len(list) != 2 {
+		t.Fatalf("reloaded queue after save = %+v", list)
+	}
+
+	// обрезанная последняя строка пропускается, испорченная в середине - ошибка
+	hooks.enqueue(streamEvent{Event: Event{Type: eventDeleted}})
+	f, _ := os.OpenFile(path+".queue", os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString(`{"id": "torn`)
+	f.Close()
+	reloaded, err = newWebhookDispatcher(path)
+	if list, _ := reloaded.pending(hook.ID); err != nil || len(list) != 3 {
+		t.Fatalf("torn tail: %v %+v", err, list)
+	}
+	f, _ = os.OpenFile(path+".queue", os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString("\n{}\n")
+	f.Close()
+	if _, err := newWebhookDispatcher(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("corrupt queue log: %v", err)
+	}
+}
+
+func TestWebhookNotSaved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	hooks, err := newWebhookDispatcher(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{t: t, h: newHandlers(newMemoryStore(nil), nil, hooks, nil)}
+	s.rebuild()
+	var hook Webhook
+	s.ok("", http.MethodPost, "/webhooks", Webhook{URL: "http://example.com/a"}, &hook)
+
+	// файл не записать: подписка изменена в памяти, ответ - 207, как при создании
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []*httptest.ResponseRecorder{
+		s.do("", http.MethodPost, "/webhooks", Webhook{URL: "http://example.com/b"}),
+		s.do("", http.MethodPut, "/webhooks/"+hook.ID, Webhook{URL: "http://example.com/c"}),
+	} {
+		var res struct {
+			Webhook Webhook `json:"webhook"`
+			Error   string  `json:"error"`
+		}
+		decodeBody(t, w, &res)
+		if w.Code != http.StatusMultiStatus || res.Webhook.URL == "" || !strings.Contains(res.Error, errNotSaved.Error()) {
+			t.Errorf("%d %s", w.Code, w.Body)
+		}
+	}
+	if got, _ := hooks.get(hook.ID); got.URL != "http://example.com/c" {
+		t.Fatalf("webhook = %+v", got)
+	}
+}